@@ -0,0 +1,364 @@
+package content
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLocked is returned for the files of a level that isn't released yet.
+var ErrLocked = errors.New("level is not released yet")
+
+// how long a level that failed to unlock, e.g. because its key couldn't be
+// fetched, answers with that failure before it's tried again
+const bundleRetry = 5 * time.Second
+
+var (
+	bundlePattern   = regexp.MustCompile(`^level(\d+)\.enc$`)
+	levelDirPattern = regexp.MustCompile(`^level(\d+)$`)
+)
+
+// LevelKeys hands out the key of a level's bundle. Bundles only asks for a
+// key once the level is released, so a source that can't be read early
+// keeps the puzzles sealed even from whoever runs the server.
+type LevelKeys interface {
+	Key(level int) ([]byte, error)
+}
+
+// KeyDir reads each level's key from its own levelN.key file of hex, when
+// the level is unlocked. The files are meant to be copied in, or mounted
+// by a secret manager, as each level is released.
+type KeyDir string
+
+func (d KeyDir) file(level int) string {
+	return filepath.Join(string(d), fmt.Sprintf("level%d.key", level))
+}
+
+func (d KeyDir) Key(level int) ([]byte, error) {
+	b, err := os.ReadFile(d.file(level))
+	if err != nil {
+		return nil, fmt.Errorf("no key for level %d yet: %v", level, err)
+	}
+	return decodeKey(level, b)
+}
+
+// Generate writes a random key for every level that doesn't have one, for
+// sealing.
+func (d KeyDir) Generate(levels []int) error {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+	for _, level := range levels {
+		if _, err := os.Stat(d.file(level)); err == nil {
+			continue
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		if err := os.WriteFile(d.file(level), []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyURL fetches each level's key from an escrow service as <URL>/levelN.key
+// when the level is unlocked. The service is trusted to refuse keys of
+// levels that aren't released yet.
+type KeyURL struct {
+	URL   string
+	Token string // sent as a bearer token when set
+}
+
+func (k KeyURL) Key(level int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	url := fmt.Sprintf("%s/level%d.key", strings.TrimSuffix(k.URL, "/"), level)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("no key for level %d yet, escrow answered %s", level, res.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return nil, err
+	}
+	return decodeKey(level, b)
+}
+
+func decodeKey(level int, b []byte) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("the key for level %d must be 64 hex characters", level)
+	}
+	return key, nil
+}
+
+// Bundles serves level directories from levelN.enc bundles, one encrypted
+// tarball per level. A level's key is only asked for, and its bundle only
+// decrypted, once the level is released, unless early says otherwise. The
+// bundle stays decrypted in memory after that.
+type Bundles struct {
+	dir       string
+	keys      LevelKeys
+	releaseAt func(level int) (time.Time, error)
+	early     func(level int) bool
+
+	mu     sync.RWMutex
+	levels map[int]*bundle
+}
+
+// bundle is the state of one level. Its mutex is held while the level is
+// unlocked, so a slow key fetch only holds up readers of that level, and
+// they share its outcome instead of each fetching the key.
+type bundle struct {
+	mu      sync.Mutex
+	fs      *memFS
+	err     error // the last failure, answered until retryAt
+	retryAt time.Time
+}
+
+// NewBundles serves the bundles in dir. releaseAt reports when a level is
+// released, returning ErrLocked for levels that aren't scheduled. It's asked
+// right before a level is decrypted, so it shouldn't answer from anything
+// that can be stale.
+func NewBundles(dir string, keys LevelKeys, releaseAt func(level int) (time.Time, error), early func(level int) bool) *Bundles {
+	return &Bundles{
+		dir:       dir,
+		keys:      keys,
+		releaseAt: releaseAt,
+		early:     early,
+		levels:    map[int]*bundle{},
+	}
+}
+
+// levelDirs lists the levels that have a bundle.
+func (b *Bundles) levelDirs() ([]int, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	var levels []int
+	for _, e := range entries {
+		if m := bundlePattern.FindStringSubmatch(e.Name()); m != nil && !e.IsDir() {
+			level, _ := strconv.Atoi(m[1])
+			levels = append(levels, level)
+		}
+	}
+	sort.Ints(levels)
+	return levels, nil
+}
+
+// bundle returns the state of a level, creating it the first time.
+func (b *Bundles) bundle(level int) *bundle {
+	b.mu.RLock()
+	l, ok := b.levels[level]
+	b.mu.RUnlock()
+	if ok {
+		return l
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l, ok = b.levels[level]; !ok {
+		l = &bundle{}
+		b.levels[level] = l
+	}
+	return l
+}
+
+// unlock decrypts a level's bundle if it's released. Failures other than
+// the level being locked are repeated for bundleRetry before trying again.
+func (b *Bundles) unlock(level int) (*memFS, error) {
+	l := b.bundle(level)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fs != nil {
+		return l.fs, nil
+	}
+	if time.Now().Before(l.retryAt) {
+		return nil, l.err
+	}
+
+	m, err := b.decrypt(level)
+	if errors.Is(err, ErrLocked) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Unable to unlock level %d, retrying in %v, %v", level, bundleRetry, err)
+		l.err, l.retryAt = err, time.Now().Add(bundleRetry)
+		return nil, err
+	}
+	l.fs = m
+	return m, nil
+}
+
+// decrypt reads and decrypts a level's bundle if it's released.
+func (b *Bundles) decrypt(level int) (*memFS, error) {
+	releaseAt, err := b.releaseAt(level)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Before(releaseAt) {
+		if !b.early(level) {
+			return nil, ErrLocked
+		}
+		log.Printf("Decrypting level %d before its release at %s, early unlock is set", level, releaseAt.Format(time.RFC3339))
+	}
+
+	key, err := b.keys.Key(level)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := os.ReadFile(filepath.Join(b.dir, fmt.Sprintf("level%d.enc", level)))
+	if err != nil {
+		return nil, err
+	}
+	m, err := unpack(sealed, key)
+	if err != nil {
+		return nil, fmt.Errorf("error unpacking level %d: %v", level, err)
+	}
+	return m, nil
+}
+
+// resolve splits a path into its level and the path inside the bundle.
+// The root and the level directories themselves are answered without
+// decrypting anything.
+func (b *Bundles) resolve(op, name string) (level int, rest string, err error) {
+	if !fs.ValidPath(name) {
+		return 0, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, rest, _ := strings.Cut(name, "/")
+	if rest == "" {
+		rest = "."
+	}
+	m := levelDirPattern.FindStringSubmatch(dir)
+	if m == nil {
+		return 0, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	level, _ = strconv.Atoi(m[1])
+	if _, err := os.Stat(filepath.Join(b.dir, dir+".enc")); err != nil {
+		return 0, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return level, rest, nil
+}
+
+func (b *Bundles) root() (*memFS, error) {
+	levels, err := b.levelDirs()
+	if err != nil {
+		return nil, err
+	}
+	m := newMemFS()
+	for _, level := range levels {
+		m.dir(fmt.Sprintf("level%d", level), time.Time{})
+	}
+	m.sort()
+	return m, nil
+}
+
+// at runs fn against the store holding name, and the name within it.
+func (b *Bundles) at(op, name string, fn func(m *memFS, name string) error) error {
+	if name == "." {
+		m, err := b.root()
+		if err != nil {
+			return err
+		}
+		return fn(m, ".")
+	}
+
+	level, rest, err := b.resolve(op, name)
+	if err != nil {
+		return err
+	}
+	if rest == "." && op == "stat" {
+		m, err := b.root()
+		if err != nil {
+			return err
+		}
+		return fn(m, name)
+	}
+
+	m, err := b.unlock(level)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return fn(m, rest)
+}
+
+func (b *Bundles) Open(name string) (f fs.File, err error) {
+	err = b.at("open", name, func(m *memFS, name string) error {
+		f, err = m.Open(name)
+		return err
+	})
+	return f, err
+}
+
+func (b *Bundles) ReadFile(name string) (data []byte, err error) {
+	err = b.at("read", name, func(m *memFS, name string) error {
+		data, err = m.ReadFile(name)
+		return err
+	})
+	return data, err
+}
+
+func (b *Bundles) ReadDir(name string) (entries []fs.DirEntry, err error) {
+	err = b.at("readdir", name, func(m *memFS, name string) error {
+		entries, err = m.ReadDir(name)
+		return err
+	})
+	return entries, err
+}
+
+func (b *Bundles) Stat(name string) (info fs.FileInfo, err error) {
+	err = b.at("stat", name, func(m *memFS, name string) error {
+		info, err = m.Stat(name)
+		return err
+	})
+	return info, err
+}
+
+// SealLevel writes a level directory of src into dir as levelN.enc.
+func SealLevel(dir string, src fs.FS, level int, key []byte) error {
+	sub, err := fs.Sub(src, fmt.Sprintf("level%d", level))
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(dir, fmt.Sprintf("level%d.enc", level))
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := PackTarball(f, FS(sub), key); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,97 @@
+// Package content provides the stores templates, static files and puzzles
+// are read from.
+package content
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+type store struct {
+	fs.FS
+}
+
+func (s store) ReadFile(name string) ([]byte, error) { return fs.ReadFile(s.FS, name) }
+
+func (s store) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(s.FS, name) }
+
+func (s store) Stat(name string) (fs.FileInfo, error) { return fs.Stat(s.FS, name) }
+
+// FS adapts any file system, such as an embed.FS, into a store.
+func FS(fsys fs.FS) globals.ContentStore {
+	return store{fsys}
+}
+
+// Dir reads from a directory on disk.
+func Dir(path string) globals.ContentStore {
+	return FS(os.DirFS(path))
+}
+
+// overlay reads from top first and falls back to base for files top
+// doesn't have.
+type overlay struct {
+	top, base globals.ContentStore
+}
+
+// Overlay lets files on disk override built in ones during development.
+func Overlay(top, base globals.ContentStore) globals.ContentStore {
+	return overlay{top, base}
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+func (o overlay) ReadFile(name string) ([]byte, error) {
+	b, err := o.top.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.ReadFile(name)
+	}
+	return b, err
+}
+
+func (o overlay) Stat(name string) (fs.FileInfo, error) {
+	info, err := o.top.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Stat(name)
+	}
+	return info, err
+}
+
+// ReadDir merges both listings, entries in top win.
+func (o overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	top, err := o.top.ReadDir(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.ReadDir(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	base, err := o.base.ReadDir(name)
+	if err != nil {
+		return top, nil
+	}
+
+	merged := map[string]fs.DirEntry{}
+	for _, e := range base {
+		merged[e.Name()] = e
+	}
+	for _, e := range top {
+		merged[e.Name()] = e
+	}
+
+	entries := make([]fs.DirEntry, 0, len(merged))
+	for _, e := range merged {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,378 @@
+package content
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func testPuzzles() fstest.MapFS {
+	return fstest.MapFS{
+		"level1/level1.md":            {Data: []byte("# One")},
+		"level1/problem_set/1.json":   {Data: []byte(`{"input": "1", "output": "2"}`)},
+		"level2/level2.md":            {Data: []byte("# Two")},
+		"level2/part2.md":             {Data: []byte("# Two, again")},
+		"level2/problem_set/1.json":   {Data: []byte(`{"input": "3", "output": "4"}`)},
+		"level2/problem_set/2.json":   {Data: []byte(`{"input": "5", "output": "6"}`)},
+		"level2/hints.json":           {Data: []byte(`[]`)},
+		"level3/level3.md":            {Data: []byte("# Three")},
+		"level3/problem_set/1.json":   {Data: []byte(`{"input": "7", "output": "8"}`)},
+		"level3/problem_set/.gitkeep": {Data: nil},
+	}
+}
+
+func writeTarball(t *testing.T, src fs.FS, key []byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := PackTarball(&buf, FS(src), key); err != nil {
+		t.Fatalf("PackTarball: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "puzzles.enc")
+	if err := os.WriteFile(file, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestTarballRoundTrip(t *testing.T) {
+	src := testPuzzles()
+	store, err := Tarball(writeTarball(t, src, testKey(1)), testKey(1))
+	if err != nil {
+		t.Fatalf("Tarball: %v", err)
+	}
+
+	for name, f := range src {
+		got, err := store.ReadFile(name)
+		if err != nil {
+			t.Errorf("ReadFile(%q): %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, f.Data) {
+			t.Errorf("ReadFile(%q) = %q, want %q", name, got, f.Data)
+		}
+	}
+	if err := fstest.TestFS(store, "level1/level1.md", "level2/part2.md", "level3/problem_set/1.json"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTarballWrongKey(t *testing.T) {
+	file := writeTarball(t, testPuzzles(), testKey(1))
+	if _, err := Tarball(file, testKey(2)); err == nil {
+		t.Fatal("unpacked with the wrong key")
+	}
+	if _, err := Tarball(file, testKey(1)[:16]); err == nil {
+		t.Fatal("unpacked with a short key")
+	}
+}
+
+func TestTarballTruncated(t *testing.T) {
+	if _, err := open([]byte("short"), testKey(1)); err == nil {
+		t.Fatal("opened a truncated ciphertext")
+	}
+}
+
+// sealedTar seals a tarball holding one file per name, bypassing
+// PackTarball so the names can be invalid.
+func sealedTar(t *testing.T, key []byte, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: 1, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("x"))
+	}
+	tw.Close()
+	zw.Close()
+	sealed, err := seal(buf.Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestUnpackRejectsInvalidPaths(t *testing.T) {
+	for _, name := range []string{"../escape.md", "/abs/level1.md", "level1/../../escape.md", ".."} {
+		if _, err := unpack(sealedTar(t, testKey(1), name), testKey(1)); err == nil {
+			t.Errorf("unpacked a tarball with %q", name)
+		}
+	}
+
+	m, err := unpack(sealedTar(t, testKey(1), "./level1/level1.md", "level1//part2.md"), testKey(1))
+	if err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	if err := fstest.TestFS(m, "level1/level1.md", "level1/part2.md"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	top := FS(fstest.MapFS{
+		"level1/level1.md": {Data: []byte("# Edited")},
+		"level4/level4.md": {Data: []byte("# Four")},
+	})
+	o := Overlay(top, FS(testPuzzles()))
+
+	b, err := o.ReadFile("level1/level1.md")
+	if err != nil || string(b) != "# Edited" {
+		t.Errorf("ReadFile = %q, %v, want the top file", b, err)
+	}
+	b, err = o.ReadFile("level2/level2.md")
+	if err != nil || string(b) != "# Two" {
+		t.Errorf("ReadFile = %q, %v, want the base file", b, err)
+	}
+
+	entries, err := o.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if fmt.Sprint(names) != "[level1 level2 level3 level4]" {
+		t.Errorf("ReadDir = %v, want both listings merged", names)
+	}
+}
+
+// countingKeys records which keys were asked for.
+type countingKeys struct {
+	keys  map[int][]byte
+	asked map[int]int
+}
+
+func (k *countingKeys) Key(level int) ([]byte, error) {
+	k.asked[level]++
+	key, ok := k.keys[level]
+	if !ok {
+		return nil, fmt.Errorf("no key for level %d", level)
+	}
+	return key, nil
+}
+
+// testBundles seals the test puzzles, level 1 released an hour ago, level 2
+// in an hour and level 3 not scheduled.
+func testBundles(t *testing.T, early func(level int) bool) (*Bundles, *countingKeys) {
+	t.Helper()
+	dir := t.TempDir()
+	keys := &countingKeys{keys: map[int][]byte{}, asked: map[int]int{}}
+	for level := 1; level <= 3; level++ {
+		keys.keys[level] = testKey(byte(level))
+		if err := SealLevel(dir, testPuzzles(), level, keys.keys[level]); err != nil {
+			t.Fatalf("SealLevel(%d): %v", level, err)
+		}
+	}
+
+	release := map[int]time.Time{
+		1: time.Now().Add(-time.Hour),
+		2: time.Now().Add(time.Hour),
+	}
+	releaseAt := func(level int) (time.Time, error) {
+		at, ok := release[level]
+		if !ok {
+			return time.Time{}, ErrLocked
+		}
+		return at, nil
+	}
+	return NewBundles(dir, keys, releaseAt, early), keys
+}
+
+func TestBundlesLocked(t *testing.T) {
+	b, keys := testBundles(t, func(int) bool { return false })
+
+	for _, name := range []string{"level2/level2.md", "level3/level3.md"} {
+		if _, err := b.ReadFile(name); !errors.Is(err, ErrLocked) {
+			t.Errorf("ReadFile(%q) = %v, want ErrLocked", name, err)
+		}
+	}
+	if _, err := b.ReadDir("level2"); !errors.Is(err, ErrLocked) {
+		t.Errorf("ReadDir(level2) = %v, want ErrLocked", err)
+	}
+	if keys.asked[2] != 0 || keys.asked[3] != 0 {
+		t.Errorf("keys of locked levels were asked for: %v", keys.asked)
+	}
+
+	// the listing and the level directories don't need any key
+	entries, err := b.ReadDir(".")
+	if err != nil || len(entries) != 3 {
+		t.Errorf("ReadDir(.) = %v, %v, want the 3 levels", entries, err)
+	}
+	if info, err := b.Stat("level2"); err != nil || !info.IsDir() {
+		t.Errorf("Stat(level2) = %v, %v, want a directory", info, err)
+	}
+
+	b1, err := b.ReadFile("level1/level1.md")
+	if err != nil || string(b1) != "# One" {
+		t.Errorf("ReadFile(level1/level1.md) = %q, %v", b1, err)
+	}
+	if _, err := b.ReadFile("level9/level9.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile of a missing level = %v, want ErrNotExist", err)
+	}
+
+	if err := fstest.TestFS(sub(t, b, "level1"), "level1.md", "problem_set/1.json"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBundlesEarlyUnlock(t *testing.T) {
+	b, keys := testBundles(t, func(level int) bool { return level == 2 })
+
+	got, err := b.ReadFile("level2/part2.md")
+	if err != nil || string(got) != "# Two, again" {
+		t.Errorf("ReadFile(level2/part2.md) = %q, %v, want it unlocked early", got, err)
+	}
+	if keys.asked[2] != 1 {
+		t.Errorf("level 2's key was asked for %d times, want once", keys.asked[2])
+	}
+	// unscheduled levels stay sealed, early or not
+	if _, err := b.ReadFile("level3/level3.md"); !errors.Is(err, ErrLocked) {
+		t.Errorf("ReadFile(level3/level3.md) = %v, want ErrLocked", err)
+	}
+
+	// decrypted levels stay in memory
+	b.ReadFile("level2/level2.md")
+	if keys.asked[2] != 1 {
+		t.Errorf("level 2's key was asked for again")
+	}
+}
+
+func TestBundlesWrongKey(t *testing.T) {
+	b, keys := testBundles(t, func(int) bool { return false })
+	keys.keys[1] = testKey(9)
+	if _, err := b.ReadFile("level1/level1.md"); err == nil || errors.Is(err, ErrLocked) {
+		t.Errorf("ReadFile with the wrong key = %v, want a decryption error", err)
+	}
+}
+
+func TestKeyDir(t *testing.T) {
+	d := KeyDir(filepath.Join(t.TempDir(), "keys"))
+	if _, err := d.Key(1); err == nil {
+		t.Fatal("read a key that doesn't exist")
+	}
+	if err := d.Generate([]int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	k1, err := d.Key(1)
+	if err != nil || len(k1) != 32 {
+		t.Fatalf("Key(1) = %x, %v", k1, err)
+	}
+
+	// existing keys are kept
+	if err := d.Generate([]int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := d.Key(1)
+	if !bytes.Equal(k1, again) {
+		t.Error("Generate replaced an existing key")
+	}
+	k3, err := d.Key(3)
+	if err != nil || bytes.Equal(k1, k3) {
+		t.Errorf("Key(3) = %x, %v, want a new key", k3, err)
+	}
+
+	os.WriteFile(filepath.Join(string(d), "level4.key"), []byte("not hex"), 0600)
+	if _, err := d.Key(4); err == nil {
+		t.Error("accepted a malformed key")
+	}
+}
+
+func sub(t *testing.T, fsys fs.FS, dir string) fs.FS {
+	t.Helper()
+	s, err := fs.Sub(fsys, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestBundlesRetry(t *testing.T) {
+	b, keys := testBundles(t, func(int) bool { return false })
+	delete(keys.keys, 1)
+
+	for range 3 {
+		if _, err := b.ReadFile("level1/level1.md"); err == nil {
+			t.Fatal("read a level without its key")
+		}
+	}
+	if keys.asked[1] != 1 {
+		t.Errorf("the key was asked for %d times during the backoff, want once", keys.asked[1])
+	}
+}
+
+// slowKeys blocks handing out a level's key until release is closed.
+type slowKeys struct {
+	slow    int
+	release chan struct{}
+	asked   atomic.Int32
+}
+
+func (k *slowKeys) Key(level int) ([]byte, error) {
+	if level == k.slow {
+		k.asked.Add(1)
+		<-k.release
+	}
+	return testKey(byte(level)), nil
+}
+
+func TestBundlesSlowKey(t *testing.T) {
+	dir := t.TempDir()
+	for level := 1; level <= 2; level++ {
+		if err := SealLevel(dir, testPuzzles(), level, testKey(byte(level))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := &slowKeys{slow: 2, release: make(chan struct{})}
+	releaseAt := func(int) (time.Time, error) { return time.Now().Add(-time.Hour), nil }
+	b := NewBundles(dir, keys, releaseAt, func(int) bool { return false })
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.ReadFile("level2/level2.md"); err != nil {
+				t.Errorf("ReadFile(level2/level2.md): %v", err)
+			}
+		}()
+	}
+
+	// the other levels don't wait on level 2's key
+	done := make(chan error)
+	go func() {
+		_, err := b.ReadFile("level1/level1.md")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ReadFile(level1/level1.md): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("level 1 waited on level 2's key")
+	}
+
+	close(keys.release)
+	wg.Wait()
+	if n := keys.asked.Load(); n != 1 {
+		t.Errorf("level 2's key was fetched %d times, want once", n)
+	}
+}
@@ -0,0 +1,307 @@
+package content
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// An encrypted tarball is a gzipped tar sealed with AES-256-GCM, stored as
+// the nonce followed by the ciphertext.
+
+// Tarball unpacks an encrypted tarball into memory.
+func Tarball(file string, key []byte) (globals.ContentStore, error) {
+	sealed, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m, err := unpack(sealed, key)
+	if err != nil {
+		return nil, fmt.Errorf("error unpacking %s: %v", file, err)
+	}
+	return m, nil
+}
+
+func unpack(sealed, key []byte) (*memFS, error) {
+	plain, err := open(sealed, key)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	m := newMemFS()
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid path %q", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			m.dir(name, hdr.ModTime)
+		case tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			m.add(name, data, hdr.ModTime)
+		}
+	}
+	m.sort()
+	return m, nil
+}
+
+// PackTarball writes every file of a store into an encrypted tarball.
+func PackTarball(w io.Writer, src globals.ContentStore, key []byte) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+
+	err := fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := src.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	sealed, err := seal(buf.Bytes(), key)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(sealed)
+	return err
+}
+
+func seal(plain, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(sealed, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("truncated ciphertext")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// memFS holds an unpacked tarball.
+type memFS struct {
+	files map[string]*memFile // by path, "." is the root
+}
+
+// memFile is both a file or directory and its fs.FileInfo.
+type memFile struct {
+	name    string
+	data    []byte
+	modTime time.Time
+	isDir   bool
+	entries []fs.DirEntry
+}
+
+func (f *memFile) Name() string       { return f.name }
+func (f *memFile) Size() int64        { return int64(len(f.data)) }
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return f.isDir }
+func (f *memFile) Sys() any           { return nil }
+
+func (f *memFile) Mode() fs.FileMode {
+	if f.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// openFile is a memFile being read.
+type openFile struct {
+	info *memFile
+	bytes.Reader
+	dirPos int // entries already returned by ReadDir
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *openFile) Read(b []byte) (int, error) {
+	if f.info.isDir {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: errors.New("is a directory")}
+	}
+	return f.Reader.Read(b)
+}
+
+// ReadDir makes open directories an fs.ReadDirFile.
+func (f *openFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.info.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: f.info.name, Err: errors.New("not a directory")}
+	}
+	entries := f.info.entries[f.dirPos:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(n, len(entries))]
+	}
+	f.dirPos += len(entries)
+	return append([]fs.DirEntry(nil), entries...), nil
+}
+
+func (f *openFile) Close() error { return nil }
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*memFile{
+		".": {name: ".", isDir: true},
+	}}
+}
+
+// dir adds a directory along with its parents.
+func (m *memFS) dir(name string, modTime time.Time) *memFile {
+	if d, ok := m.files[name]; ok {
+		return d
+	}
+	d := &memFile{name: path.Base(name), modTime: modTime, isDir: true}
+	m.files[name] = d
+	parent := m.dir(path.Dir(name), modTime)
+	parent.entries = append(parent.entries, fs.FileInfoToDirEntry(d))
+	return d
+}
+
+func (m *memFS) add(name string, data []byte, modTime time.Time) {
+	if _, ok := m.files[name]; ok {
+		return
+	}
+	f := &memFile{name: path.Base(name), data: data, modTime: modTime}
+	m.files[name] = f
+	parent := m.dir(path.Dir(name), modTime)
+	parent.entries = append(parent.entries, fs.FileInfoToDirEntry(f))
+}
+
+func (m *memFS) sort() {
+	for _, f := range m.files {
+		sort.Slice(f.entries, func(i, j int) bool { return f.entries[i].Name() < f.entries[j].Name() })
+	}
+}
+
+func (m *memFS) lookup(op, name string) (*memFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	f, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	of := &openFile{info: f}
+	of.Reset(f.data)
+	return of, nil
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	f, err := m.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.isDir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return bytes.Clone(f.data), nil
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return append([]fs.DirEntry(nil), f.entries...), nil
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
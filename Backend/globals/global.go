@@ -2,6 +2,7 @@ package globals
 
 import (
 	"html/template"
+	"io/fs"
 	"net/http"
 	"time"
 
@@ -11,6 +12,38 @@ import (
 var Hostname string
 var Port string
 
+// when the public leaderboards freeze, zero if they never do
+var FreezeAt time.Time
+
+// how long after a level's release solving it still counts towards the
+// daily streak
+var StreakWindow = 24 * time.Hour
+
+// team mode settings
+var TeamMode bool
+var TeamMaxSize = 4
+var TeamScoring = TeamScoringUnion
+
+// when the event ends, zero if not set
+var EventEnd time.Time
+
+// how long after a level's release its writeups open, they all open at
+// EventEnd regardless
+var WriteupWindow = 48 * time.Hour
+
+// levels a user must solve to be issued a certificate, 0 for every level
+var CertificateMinLevels int
+
+// where level releases are posted, empty to not post them
+var NotifyWebhook string
+
+const (
+	// a team is credited with every level any member solved
+	TeamScoringUnion = "union"
+	// a team scores the points of its best member on each level
+	TeamScoringBest = "best"
+)
+
 type User struct {
 	Github_id    int64  `json:"id"`
 	Username     string `json:"login"`
@@ -36,7 +69,10 @@ type SessionData struct {
 	InputID          int
 	CurrentLevel     int
 	Streak           int
+	LongestStreak    int
+	FirstTryStreak   int
 	NextReleaseLevel int
+	IsAdmin          bool
 	CreatedAt        time.Time
 }
 
@@ -74,3 +110,17 @@ func RenderInfoPage(tpl *template.Template, w http.ResponseWriter, loggedIn bool
 }
 
 var DB *pgxpool.Pool
+
+// ContentStore is a read-only tree of files, read from disk, embedded in the
+// binary or unpacked from an encrypted bundle.
+type ContentStore interface {
+	fs.ReadFileFS
+	fs.ReadDirFS
+	fs.StatFS
+}
+
+// where schema.sql and static/ are read from
+var Assets ContentStore
+
+// where the levelN directories are read from
+var Puzzles ContentStore
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// heatmap cell size and spacing in px
+const (
+	heatmapCell = 11
+	heatmapGap  = 3
+)
+
+// heatmap shades from no solves to 4 or more solves in a day
+var heatmapShades = []string{"#1c1612", "#5a4320", "#9c7530", "#ecc67e", "#ffc548"}
+
+// timeline bar sizes in px
+const (
+	timelineRow   = 20
+	timelineLabel = 64
+	timelineBar   = 320
+)
+
+type timelineLevel struct {
+	LevelID   int
+	TimeTaken *time.Duration // nil while unsolved
+}
+
+// ActivityHeatmapHandler serves /u/{username}/heatmap.svg so the heatmap can
+// be embedded outside the site.
+func ActivityHeatmapHandler(w http.ResponseWriter, r *http.Request) {
+	githubID, ok := svgProfile(w, r)
+	if !ok {
+		return
+	}
+
+	svg, err := activityHeatmap(r.Context(), githubID)
+	if err != nil {
+		log.Printf("error rendering the heatmap, %v", err)
+		http.Error(w, "Unable to render the heatmap", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	fmt.Fprint(w, svg)
+}
+
+// LevelTimelineHandler serves /u/{username}/timeline.svg.
+func LevelTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	githubID, ok := svgProfile(w, r)
+	if !ok {
+		return
+	}
+
+	svg, err := levelTimeline(r.Context(), githubID)
+	if err != nil {
+		log.Printf("error rendering the timeline, %v", err)
+		http.Error(w, "Unable to render the timeline", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	fmt.Fprint(w, svg)
+}
+
+// svgProfile resolves the {username} of an svg route, answering 404 for
+// unknown users and for hidden profiles viewed by anyone but their owner.
+func svgProfile(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	ctx := r.Context()
+
+	githubID, found, err := profileID(ctx, r.PathValue("username"))
+	if err != nil {
+		log.Printf("error looking up the profile, %v", err)
+		http.Error(w, "Unable to find the user", http.StatusInternalServerError)
+		return 0, false
+	}
+
+	var viewer int64
+	if sdata, ok := optionalSession(r); ok {
+		viewer = sdata.GithubID
+	}
+	visible := false
+	if found {
+		visible, err = profileVisible(ctx, githubID, viewer)
+		if err != nil {
+			log.Printf("error checking profile visibility, %v", err)
+			http.Error(w, "Unable to find the user", http.StatusInternalServerError)
+			return 0, false
+		}
+	}
+	if !visible {
+		http.NotFound(w, r)
+		return 0, false
+	}
+	return githubID, true
+}
+
+// activityHeatmap renders a calendar of solves per day, one column per week,
+// spanning from the first level release to the last release or solve.
+func activityHeatmap(ctx context.Context, githubID int64) (string, error) {
+	var first, last *time.Time
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT MIN(release_time), MAX(release_time) FROM levels
+	    `).Scan(&first, &last)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT DATE_TRUNC('day', last_submission), COUNT(*)
+	    FROM submissions
+	    WHERE github_id = $1 AND passed = TRUE
+	    GROUP BY 1
+	    `, githubID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	solves := map[time.Time]int{}
+	for rows.Next() {
+		var day time.Time
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return "", err
+		}
+		solves[day] = count
+		if last == nil || day.After(*last) {
+			last = &day
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if first == nil {
+		return renderHeatmap(time.Time{}, time.Time{}, solves), nil
+	}
+	return renderHeatmap(first.Truncate(24*time.Hour), last.Truncate(24*time.Hour), solves), nil
+}
+
+func renderHeatmap(start, end time.Time, solves map[time.Time]int) string {
+	const left, top = 28, 16
+	step := heatmapCell + heatmapGap
+
+	weeks := 0
+	origin := start.AddDate(0, 0, -int(start.Weekday()))
+	if !start.IsZero() {
+		weeks = int(end.Sub(origin).Hours()/24)/7 + 1
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="9" fill="#ecc67e">`,
+		left+max(weeks, 1)*step, top+7*step)
+	for i, day := range []string{"Mon", "Wed", "Fri"} {
+		fmt.Fprintf(&b, `<text x="0" y="%d">%s</text>`, top+(2*i+1)*step+heatmapCell-2, day)
+	}
+
+	month := -1
+	for week := range weeks {
+		weekStart := origin.AddDate(0, 0, 7*week)
+		if m := int(weekStart.AddDate(0, 0, 6).Month()); m != month {
+			month = m
+			fmt.Fprintf(&b, `<text x="%d" y="10">%s</text>`, left+week*step, weekStart.AddDate(0, 0, 6).Format("Jan"))
+		}
+
+		for weekday := range 7 {
+			day := weekStart.AddDate(0, 0, weekday)
+			if day.Before(start) || day.After(end) {
+				continue
+			}
+			count := solves[day]
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="%s"><title>%s</title></rect>`,
+				left+week*step, top+weekday*step, heatmapCell, heatmapCell,
+				heatmapShades[min(count, len(heatmapShades)-1)], solvesTitle(count, day))
+		}
+	}
+
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func solvesTitle(count int, day time.Time) string {
+	switch count {
+	case 0:
+		return "No solves on " + day.Format("Jan 2")
+	case 1:
+		return "1 solve on " + day.Format("Jan 2")
+	}
+	return fmt.Sprintf("%d solves on %s", count, day.Format("Jan 2"))
+}
+
+// levelTimeline renders one bar per released level, as long as it took the
+// user to solve it after release, scaled against their slowest solve.
+func levelTimeline(ctx context.Context, githubID int64) (string, error) {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT l.level_id, s.time_taken
+	    FROM levels l
+	    LEFT JOIN submissions s
+	        ON s.level_id = l.level_id AND s.github_id = $1 AND s.passed = TRUE
+	    WHERE l.release_time <= NOW()
+	    ORDER BY l.level_id
+	    `, githubID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var levels []timelineLevel
+	for rows.Next() {
+		var l timelineLevel
+		if err := rows.Scan(&l.LevelID, &l.TimeTaken); err != nil {
+			return "", err
+		}
+		levels = append(levels, l)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return renderTimeline(levels), nil
+}
+
+func renderTimeline(levels []timelineLevel) string {
+	var slowest time.Duration
+	for _, l := range levels {
+		if l.TimeTaken != nil {
+			slowest = max(slowest, *l.TimeTaken)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="11" fill="#ecc67e">`,
+		timelineLabel+timelineBar+96, max(len(levels), 1)*timelineRow)
+	for i, l := range levels {
+		y := i * timelineRow
+		fmt.Fprintf(&b, `<text x="0" y="%d">Level %d</text>`, y+14, l.LevelID)
+
+		if l.TimeTaken == nil {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" fill="#5a4320">unsolved</text>`, timelineLabel, y+14)
+			continue
+		}
+
+		width := timelineBar
+		if slowest > 0 {
+			width = max(2, int(float64(timelineBar)*float64(*l.TimeTaken)/float64(slowest)))
+		}
+		taken := l.TimeTaken.Round(time.Second)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="#ffc548"><title>Solved %v after release</title></rect>`,
+			timelineLabel, y+4, width, timelineRow-8, taken)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%v</text>`, timelineLabel+width+6, y+14, taken)
+	}
+
+	b.WriteString(`</svg>`)
+	return b.String()
+}
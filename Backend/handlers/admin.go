@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+func AdminHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		freeze, frozen, err := fetchFreeze(ctx)
+		if err != nil {
+			log.Printf("error fetching the leaderboard freeze, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		hintUsage, err := fetchHintUsage(ctx)
+		if err != nil {
+			log.Printf("error fetching hint usage, %v", err)
+		}
+
+		jobs, err := fetchReleaseJobs(ctx)
+		if err != nil {
+			log.Printf("error fetching release jobs, %v", err)
+		}
+
+		announcements, err := fetchAnnouncements(ctx)
+		if err != nil {
+			log.Printf("error fetching announcements, %v", err)
+		}
+
+		err = tpl.ExecuteTemplate(w, "admin", map[string]any{
+			"LoggedIn":           true,
+			"Admin":              true,
+			"FreezeAt":           globals.FreezeAt,
+			"Frozen":             frozen,
+			"Freeze":             freeze,
+			"HintUsage":          hintUsage,
+			"Jobs":               jobs,
+			"JobNames":           releaseJobNames(),
+			"Announcements":      announcements,
+			"AnnouncementLength": announcementMaxLength,
+			"Severities":         announcementSeverities,
+		})
+		if err != nil {
+			log.Printf("error executing the template, %v", err)
+		}
+	}
+}
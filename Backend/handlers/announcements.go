@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+const announcementMaxLength = 5000
+
+// most severe first, the order banners are shown in
+var announcementSeverities = []string{"critical", "warning", "info"}
+
+// the datetime-local format of the admin form, times are UTC
+const announcementTimeFormat = "2006-01-02T15:04"
+
+type announcement struct {
+	ID        int           `json:"id"`
+	Title     string        `json:"title"`
+	Body      string        `json:"body"`
+	HTML      template.HTML `json:"-"`
+	Severity  string        `json:"severity"`
+	StartsAt  time.Time     `json:"starts_at"`
+	EndsAt    *time.Time    `json:"ends_at,omitempty"`
+	LevelID   *int          `json:"level,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Status tells admins whether the announcement is showing.
+func (a announcement) Status() string {
+	now := time.Now()
+	switch {
+	case a.StartsAt.After(now):
+		return "scheduled"
+	case a.EndsAt != nil && !a.EndsAt.After(now):
+		return "ended"
+	}
+	return "showing"
+}
+
+// Public reports whether everyone can see the announcement, rather than
+// only users on one level.
+func (a announcement) Public() bool {
+	return a.LevelID == nil
+}
+
+const announcementColumns = `
+    a.announcement_id, a.title, a.body, a.severity, a.starts_at::TIMESTAMPTZ,
+    a.ends_at::TIMESTAMPTZ, a.level_id, a.created_at::TIMESTAMPTZ`
+
+func scanAnnouncements(ctx context.Context, query string, args ...any) ([]announcement, error) {
+	rows, err := globals.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []announcement
+	for rows.Next() {
+		var a announcement
+		err := rows.Scan(&a.ID, &a.Title, &a.Body, &a.Severity, &a.StartsAt, &a.EndsAt, &a.LevelID, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		a.HTML, err = renderUserMarkdown([]byte(a.Body))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// fetchAnnouncements lists every announcement, newest first, with their
+// bodies rendered.
+func fetchAnnouncements(ctx context.Context) ([]announcement, error) {
+	return scanAnnouncements(ctx, `
+	    SELECT `+announcementColumns+`
+	    FROM announcements a
+	    ORDER BY a.starts_at DESC, a.announcement_id DESC
+	    `)
+}
+
+// activeAnnouncements lists the announcements showing to a user right now,
+// leaving out the ones they dismissed. Logged out visitors, githubID 0, only
+// get the ones meant for everyone.
+func activeAnnouncements(ctx context.Context, githubID int64, level int) ([]announcement, error) {
+	return scanAnnouncements(ctx, `
+	    SELECT `+announcementColumns+`
+	    FROM announcements a
+	    WHERE a.starts_at <= NOW() AND (a.ends_at IS NULL OR a.ends_at > NOW())
+	        AND (a.level_id IS NULL OR a.level_id = $2)
+	        AND NOT EXISTS (
+	            SELECT 1 FROM announcement_dismissals d
+	            WHERE d.announcement_id = a.announcement_id AND d.github_id = $1
+	        )
+	    ORDER BY array_position($3::TEXT[], a.severity), a.starts_at DESC
+	    `, githubID, level, announcementSeverities)
+}
+
+// viewerAnnouncements is activeAnnouncements for whoever made the request.
+func viewerAnnouncements(r *http.Request) ([]announcement, bool, error) {
+	sdata, loggedIn := optionalSession(r)
+	list, err := activeAnnouncements(r.Context(), sdata.GithubID, sdata.CurrentLevel)
+	return list, loggedIn, err
+}
+
+// AnnouncementsHandler renders the banner base.html loads on every page.
+func AnnouncementsHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, loggedIn, err := viewerAnnouncements(r)
+		if err != nil {
+			// the page works without its banner
+			log.Printf("error fetching announcements, %v", err)
+		}
+
+		w.Header().Set("Cache-Control", "private, no-cache")
+		err = tpl.ExecuteTemplate(w, "announcements", map[string]any{
+			"LoggedIn":      loggedIn,
+			"Announcements": list,
+		})
+		if err != nil {
+			log.Printf("error executing the template, %v", err)
+		}
+	}
+}
+
+// AnnouncementsAPIHandler serves the announcements showing to the viewer as
+// json.
+func AnnouncementsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	list, _, err := viewerAnnouncements(r)
+	if err != nil {
+		log.Printf("error fetching announcements, %v", err)
+		http.Error(w, "Unable to fetch announcements", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []announcement{}
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"announcements": list}); err != nil {
+		log.Printf("error encoding announcements, %v", err)
+	}
+}
+
+// DismissAnnouncementHandler hides an announcement from the user for good.
+// It answers the banner's htmx request with nothing, removing the banner.
+func DismissAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid url request", http.StatusBadRequest)
+		return
+	}
+
+	_, err = globals.DB.Exec(ctx, `
+	    INSERT INTO announcement_dismissals (announcement_id, github_id)
+	    SELECT announcement_id, $2 FROM announcements WHERE announcement_id = $1
+	    ON CONFLICT DO NOTHING
+	    `, id, sdata.GithubID)
+	if err != nil {
+		log.Printf("error dismissing the announcement, %v", err)
+		http.Error(w, "Unable to dismiss the announcement", http.StatusInternalServerError)
+	}
+}
+
+// parseAnnouncementTime reads an optional time from the admin form.
+func parseAnnouncementTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(announcementTimeFormat, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateAnnouncementHandler posts an announcement from the admin page.
+func CreateAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	body := strings.TrimSpace(r.FormValue("body"))
+	if title == "" || body == "" || len(body) > announcementMaxLength {
+		http.Error(w, "An announcement needs a title and a body", http.StatusBadRequest)
+		return
+	}
+
+	severity := r.FormValue("severity")
+	if !slices.Contains(announcementSeverities, severity) {
+		http.Error(w, "Unknown severity", http.StatusBadRequest)
+		return
+	}
+
+	startsAt, err := parseAnnouncementTime(r.FormValue("starts_at"))
+	if err != nil {
+		http.Error(w, "Invalid start time", http.StatusBadRequest)
+		return
+	}
+	endsAt, err := parseAnnouncementTime(r.FormValue("ends_at"))
+	if err != nil {
+		http.Error(w, "Invalid end time", http.StatusBadRequest)
+		return
+	}
+	if endsAt != nil {
+		start := time.Now()
+		if startsAt != nil && startsAt.After(start) {
+			start = *startsAt
+		}
+		if !endsAt.After(start) {
+			http.Error(w, "The end time must be after the start and in the future", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var level *int
+	if v := r.FormValue("level"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid level", http.StatusBadRequest)
+			return
+		}
+		level = &n
+	}
+
+	_, err = globals.DB.Exec(ctx, `
+	    INSERT INTO announcements (title, body, github_id, severity, starts_at, ends_at, level_id)
+	    VALUES ($1, $2, $3, $4, COALESCE($5::TIMESTAMPTZ, NOW()), $6::TIMESTAMPTZ, $7)
+	    `, title, body, sdata.GithubID, severity, startsAt, endsAt, level)
+	if err != nil {
+		log.Printf("error creating the announcement, %v", err)
+		http.Error(w, "Unable to create the announcement", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s announced %q", sdata.Username, title)
+	http.Redirect(w, r, "/admin#announcements", http.StatusSeeOther)
+}
+
+// DeleteAnnouncementHandler removes an announcement, from the feed too.
+func DeleteAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid url request", http.StatusBadRequest)
+		return
+	}
+
+	_, err = globals.DB.Exec(ctx, `DELETE FROM announcements WHERE announcement_id = $1`, id)
+	if err != nil {
+		log.Printf("error deleting the announcement, %v", err)
+		http.Error(w, "Unable to delete the announcement", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s deleted announcement %d", sdata.Username, id)
+	http.Redirect(w, r, "/admin#announcements", http.StatusSeeOther)
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// badge is an achievement rule. Earned is evaluated after every committed
+// submission of a user who doesn't hold the badge yet, and returns when the
+// badge was earned, or nil while it isn't, so awards made later by a
+// backfill still carry the time of the solve that earned them.
+type badge struct {
+	Slug        string
+	Name        string
+	Description string
+	Earned      func(ctx context.Context, q querier, githubID int64) (*time.Time, error)
+}
+
+type awardedBadge struct {
+	Slug        string
+	Name        string
+	Description string
+	AwardedAt   time.Time
+}
+
+var badges []badge
+
+// registerBadge adds a rule to the achievement engine. Slugs are stored with
+// every award, so they must stay stable once the event is running.
+func registerBadge(b badge) {
+	for _, existing := range badges {
+		if existing.Slug == b.Slug {
+			log.Fatalf("badge %q registered twice", b.Slug)
+		}
+	}
+	badges = append(badges, b)
+}
+
+func badgeBySlug(slug string) (badge, bool) {
+	for _, b := range badges {
+		if b.Slug == slug {
+			return b, true
+		}
+	}
+	return badge{}, false
+}
+
+func init() {
+	registerBadge(badge{
+		Slug:        "first-blood",
+		Name:        "First Blood",
+		Description: "Made the first correct submission of the event",
+		Earned: func(ctx context.Context, q querier, githubID int64) (*time.Time, error) {
+			var at *time.Time
+			err := q.QueryRow(ctx, `
+			    SELECT (
+			        SELECT CASE WHEN github_id = $1 THEN last_submission END
+			        FROM submissions
+			        WHERE passed = TRUE
+			        ORDER BY last_submission
+			        LIMIT 1
+			    )`, githubID).Scan(&at)
+			return at, err
+		},
+	})
+
+	registerBadge(badge{
+		Slug:        "week-streak",
+		Name:        "On a Roll",
+		Description: "Kept a 7 day streak",
+		Earned: func(ctx context.Context, q querier, githubID int64) (*time.Time, error) {
+			levels, err := releasedLevels(ctx, q)
+			if err != nil {
+				return nil, err
+			}
+			solves, err := userSolves(ctx, q, githubID)
+			if err != nil {
+				return nil, err
+			}
+			if at, ok := streakReachedAt(levels, solves, globals.StreakWindow, 7); ok {
+				return &at, nil
+			}
+			return nil, nil
+		},
+	})
+
+	registerBadge(badge{
+		Slug:        "speedrunner",
+		Name:        "Speedrunner",
+		Description: "Solved a level within 10 minutes of its release",
+		Earned: func(ctx context.Context, q querier, githubID int64) (*time.Time, error) {
+			var at *time.Time
+			err := q.QueryRow(ctx, `
+			    SELECT MIN(last_submission) FROM submissions
+			    WHERE github_id = $1 AND passed = TRUE
+			    AND time_taken <= INTERVAL '10 minutes'
+			    `, githubID).Scan(&at)
+			return at, err
+		},
+	})
+
+	registerBadge(badge{
+		Slug:        "flawless",
+		Name:        "Flawless",
+		Description: "Solved 5 levels without a single wrong answer",
+		Earned: func(ctx context.Context, q querier, githubID int64) (*time.Time, error) {
+			var at *time.Time
+			err := q.QueryRow(ctx, `
+			    SELECT (
+			        SELECT last_submission FROM submissions
+			        WHERE github_id = $1 AND passed = TRUE AND attempts = 1
+			        ORDER BY last_submission
+			        OFFSET 4 LIMIT 1
+			    )`, githubID).Scan(&at)
+			return at, err
+		},
+	})
+
+	registerBadge(badge{
+		Slug:        "completionist",
+		Name:        "Completionist",
+		Description: "Solved every level of the event",
+		Earned: func(ctx context.Context, q querier, githubID int64) (*time.Time, error) {
+			var at *time.Time
+			err := q.QueryRow(ctx, `
+			    SELECT CASE WHEN COUNT(*) > 0 AND COUNT(*) = COUNT(s.level_id)
+			        THEN MAX(s.last_submission) END
+			    FROM levels l
+			    LEFT JOIN submissions s
+			        ON s.level_id = l.level_id AND s.github_id = $1 AND s.passed = TRUE
+			    `, githubID).Scan(&at)
+			return at, err
+		},
+	})
+}
+
+// evaluateBadges checks every badge the user doesn't hold yet and awards the
+// ones they have earned, returning the newly awarded slugs.
+func evaluateBadges(ctx context.Context, githubID int64) ([]string, error) {
+	held := map[string]bool{}
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT badge FROM user_badges WHERE github_id = $1
+	    `, githubID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching badges: %v", err)
+	}
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		held[slug] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var awarded []string
+	for _, b := range badges {
+		if held[b.Slug] {
+			continue
+		}
+
+		earnedAt, err := b.Earned(ctx, globals.DB, githubID)
+		if err != nil {
+			return awarded, fmt.Errorf("error evaluating badge %s: %v", b.Slug, err)
+		}
+		if earnedAt == nil {
+			continue
+		}
+
+		_, err = globals.DB.Exec(ctx, `
+		    INSERT INTO user_badges (github_id, badge, awarded_at)
+		    VALUES ($1, $2, $3)
+		    ON CONFLICT DO NOTHING
+		    `, githubID, b.Slug, *earnedAt)
+		if err != nil {
+			return awarded, fmt.Errorf("error awarding badge %s: %v", b.Slug, err)
+		}
+		awarded = append(awarded, b.Slug)
+	}
+
+	return awarded, nil
+}
+
+// userBadges lists the badges a user holds, oldest first. Awards for badges
+// that are no longer registered are skipped.
+func userBadges(ctx context.Context, githubID int64) ([]awardedBadge, error) {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT badge, awarded_at FROM user_badges
+	    WHERE github_id = $1
+	    ORDER BY awarded_at
+	    `, githubID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var awarded []awardedBadge
+	for rows.Next() {
+		var slug string
+		var at time.Time
+		if err := rows.Scan(&slug, &at); err != nil {
+			return nil, err
+		}
+		if b, ok := badgeBySlug(slug); ok {
+			awarded = append(awarded, awardedBadge{b.Slug, b.Name, b.Description, at})
+		}
+	}
+	return awarded, rows.Err()
+}
+
+// BackfillBadges evaluates every badge for every user, for awarding badges
+// registered after submissions were made. The awards are dated to when the
+// badges were earned rather than to the backfill.
+func BackfillBadges(ctx context.Context) error {
+	rows, err := globals.DB.Query(ctx, `SELECT github_id FROM users`)
+	if err != nil {
+		return fmt.Errorf("error fetching users: %v", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var githubID int64
+		if err := rows.Scan(&githubID); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, githubID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	total := 0
+	for _, githubID := range ids {
+		awarded, err := evaluateBadges(ctx, githubID)
+		if err != nil {
+			return err
+		}
+		total += len(awarded)
+	}
+
+	log.Printf("Awarded %d badges across %d users", total, len(ids))
+	return nil
+}
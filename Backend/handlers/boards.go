@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// runes shown on every private board, in display order
+var boardRunes = []string{ScoreRune, StreakRune, ChampionRune, FlashRune}
+
+type privateBoard struct {
+	BoardID   int
+	Name      string
+	OwnerID   int64
+	JoinCode  string
+	Members   int
+	CreatedAt time.Time
+}
+
+type boardMember struct {
+	GithubID int64
+	Username string
+	JoinedAt time.Time
+}
+
+// BoardsHandler lists the private boards the user belongs to, along with
+// the forms to create or join one.
+func BoardsHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		rows, err := globals.DB.Query(ctx, `
+		    SELECT b.board_id, b.name, b.owner_id, b.join_code, b.created_at,
+		        (SELECT COUNT(*) FROM private_board_members c WHERE c.board_id = b.board_id)
+		    FROM private_boards b
+		    JOIN private_board_members m ON m.board_id = b.board_id
+		    WHERE m.github_id = $1
+		    ORDER BY m.joined_at
+		    `, sdata.GithubID)
+		if err != nil {
+			log.Printf("error fetching private boards, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+		defer rows.Close()
+
+		var boards []privateBoard
+		for rows.Next() {
+			var b privateBoard
+			err := rows.Scan(&b.BoardID, &b.Name, &b.OwnerID, &b.JoinCode, &b.CreatedAt, &b.Members)
+			if err != nil {
+				log.Printf("error scanning the row for private boards, %v", err)
+				globals.RenderInfoPage(tpl, w, true, map[string]any{
+					"Unexpected": true,
+				})
+				return
+			}
+			boards = append(boards, b)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("error fetching private boards, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		tpl.ExecuteTemplate(w, "boards", map[string]any{
+			"LoggedIn": true,
+			"Boards":   true,
+			"List":     boards,
+			"GithubID": sdata.GithubID,
+		})
+	}
+}
+
+// BoardHandler shows the runes of a single private board, restricted to its
+// members. Only members may view it; the owner also sees the join code and
+// member management.
+func BoardHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		board, err := boardForMember(ctx, r.PathValue("id"), sdata.GithubID)
+		if err != nil {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		members, err := boardMembers(ctx, board.BoardID)
+		if err != nil {
+			log.Printf("error fetching members of board %d, %v", board.BoardID, err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		tpl.ExecuteTemplate(w, "boards", map[string]any{
+			"LoggedIn":    true,
+			"Board":       board,
+			"IsOwner":     board.OwnerID == sdata.GithubID,
+			"BoardRunes":  boardRunes,
+			"BoardMember": members,
+			"GithubID":    sdata.GithubID,
+		})
+	}
+}
+
+func CreateBoardHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || len(name) > 64 {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		boardID, err := createBoard(ctx, name, sdata.GithubID)
+		if err != nil {
+			log.Printf("error creating private board, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/boards/%d", boardID), http.StatusSeeOther)
+	}
+}
+
+func JoinBoardHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		code := strings.ToUpper(strings.TrimSpace(r.FormValue("code")))
+
+		var boardID int
+		err := globals.DB.QueryRow(ctx, `
+		    SELECT board_id FROM private_boards WHERE join_code = $1
+		    `, code).Scan(&boardID)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				log.Printf("error looking up join code, %v", err)
+			}
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidJoinCode": true,
+			})
+			return
+		}
+
+		_, err = globals.DB.Exec(ctx, `
+		    INSERT INTO private_board_members (board_id, github_id)
+		    VALUES ($1, $2)
+		    ON CONFLICT DO NOTHING
+		    `, boardID, sdata.GithubID)
+		if err != nil {
+			log.Printf("error joining board %d, %v", boardID, err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/boards/%d", boardID), http.StatusSeeOther)
+	}
+}
+
+// RegenerateBoardCodeHandler replaces the join code of a board, so the old
+// one stops working. Existing members are kept.
+func RegenerateBoardCodeHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		board, err := boardForMember(ctx, r.PathValue("id"), sdata.GithubID)
+		if err != nil || board.OwnerID != sdata.GithubID {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		err = withJoinCode("private_boards_join_code_key", func(code string) error {
+			_, err := globals.DB.Exec(ctx, `
+			    UPDATE private_boards SET join_code = $1 WHERE board_id = $2
+			    `, code, board.BoardID)
+			return err
+		})
+		if err != nil {
+			log.Printf("error regenerating the code of board %d, %v", board.BoardID, err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/boards/%d", board.BoardID), http.StatusSeeOther)
+	}
+}
+
+// RemoveBoardMemberHandler lets the owner remove a member, or any member
+// remove themselves. The owner can't leave their own board.
+func RemoveBoardMemberHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		board, err := boardForMember(ctx, r.PathValue("id"), sdata.GithubID)
+		if err != nil {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		member, err := strconv.ParseInt(r.PathValue("member"), 10, 64)
+		if err != nil || member == board.OwnerID ||
+			(member != sdata.GithubID && board.OwnerID != sdata.GithubID) {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		_, err = globals.DB.Exec(ctx, `
+		    DELETE FROM private_board_members
+		    WHERE board_id = $1 AND github_id = $2
+		    `, board.BoardID, member)
+		if err != nil {
+			log.Printf("error removing %d from board %d, %v", member, board.BoardID, err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		if member == sdata.GithubID {
+			http.Redirect(w, r, "/boards", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/boards/%d", board.BoardID), http.StatusSeeOther)
+	}
+}
+
+func createBoard(ctx context.Context, name string, ownerID int64) (int, error) {
+	var boardID int
+
+	// the board and its owner's membership go in as a single statement, so
+	// a colliding join code can simply be retried
+	err := withJoinCode("private_boards_join_code_key", func(code string) error {
+		return globals.DB.QueryRow(ctx, `
+		    WITH board AS (
+		        INSERT INTO private_boards (name, owner_id, join_code)
+		        VALUES ($1, $2, $3)
+		        RETURNING board_id
+		    )
+		    INSERT INTO private_board_members (board_id, github_id)
+		    SELECT board_id, $2 FROM board
+		    RETURNING board_id
+		    `, name, ownerID, code).Scan(&boardID)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting private board: %v", err)
+	}
+	return boardID, nil
+}
+
+// boardForMember fetches a board by its id path value, failing unless
+// githubID is one of its members.
+func boardForMember(ctx context.Context, id string, githubID int64) (privateBoard, error) {
+	var b privateBoard
+
+	boardID, err := strconv.Atoi(id)
+	if err != nil {
+		return b, err
+	}
+
+	err = globals.DB.QueryRow(ctx, `
+	    SELECT b.board_id, b.name, b.owner_id, b.join_code, b.created_at
+	    FROM private_boards b
+	    JOIN private_board_members m ON m.board_id = b.board_id
+	    WHERE b.board_id = $1 AND m.github_id = $2
+	    `, boardID, githubID).Scan(&b.BoardID, &b.Name, &b.OwnerID, &b.JoinCode, &b.CreatedAt)
+	return b, err
+}
+
+func boardMembers(ctx context.Context, boardID int) ([]boardMember, error) {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT u.github_id, u.username, m.joined_at
+	    FROM private_board_members m
+	    JOIN users u ON u.github_id = m.github_id
+	    WHERE m.board_id = $1
+	    ORDER BY m.joined_at
+	    `, boardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []boardMember
+	for rows.Next() {
+		var m boardMember
+		if err := rows.Scan(&m.GithubID, &m.Username, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// canViewBoard reports whether the viewer of a rune query may see the board
+// it is restricted to. Queries without a board are public.
+func canViewBoard(ctx context.Context, q leaderboardQuery) bool {
+	if q.Board == 0 {
+		return true
+	}
+	if q.ViewerID == 0 {
+		return false
+	}
+
+	var member bool
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT EXISTS (
+	        SELECT 1 FROM private_board_members
+	        WHERE board_id = $1 AND github_id = $2
+	    )`, q.Board, q.ViewerID).Scan(&member)
+	if err != nil {
+		log.Printf("error checking board membership, %v", err)
+		return false
+	}
+	return member
+}
+
+// withJoinCode calls fn with fresh join codes until one doesn't violate
+// constraint, the unique constraint on the code's column.
+func withJoinCode(constraint string, fn func(code string) error) error {
+	return withCode(constraint, generateJoinCode, fn)
+}
+
+// withCode calls fn with codes from generate until one doesn't violate
+// constraint. Violations of any other constraint are returned straight away,
+// a new code won't fix those.
+func withCode(constraint string, generate func() string, fn func(code string) error) error {
+	var err error
+	for range 5 {
+		err = fn(generate())
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint {
+			continue
+		}
+		return err
+	}
+	return err
+}
+
+func generateJoinCode() string {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Failed to generate join code")
+	}
+	return base32.StdEncoding.EncodeToString(b)
+}
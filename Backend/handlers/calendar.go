@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+const (
+	// how long a release lasts on the calendar
+	calendarEventLength = time.Hour
+
+	// how long before a release the personal feeds remind the user
+	calendarReminder = 15 * time.Minute
+)
+
+// icsEscaper escapes TEXT values, RFC 5545 section 3.3.11.
+var icsEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icsWriter builds an iCalendar file, folding long lines at 75 octets.
+type icsWriter struct {
+	strings.Builder
+}
+
+func (c *icsWriter) line(name, value string) {
+	l := name + ":" + value
+	for len(l) > 75 {
+		// continuation lines start with a space, which counts towards the 75
+		cut := 75
+		for cut > 1 && !utf8Start(l[cut]) {
+			cut--
+		}
+		c.WriteString(l[:cut] + "\r\n")
+		l = " " + l[cut:]
+	}
+	c.WriteString(l + "\r\n")
+}
+
+func (c *icsWriter) text(name, value string) {
+	c.line(name, icsEscaper.Replace(value))
+}
+
+// utf8Start reports whether b starts a character, so folding never splits
+// one.
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func icsDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%dM", int(d/time.Minute))
+}
+
+// writeCalendar writes a VEVENT for every level. solved is nil for the
+// public feed, personal feeds mark the levels in it as solved and carry
+// reminders for the rest.
+func writeCalendar(w http.ResponseWriter, levels []scheduledLevel, solved map[int]bool) {
+	now := time.Now()
+	base := fmt.Sprintf("http://%s:%s", globals.Hostname, globals.Port)
+
+	var c icsWriter
+	c.line("BEGIN", "VCALENDAR")
+	c.line("VERSION", "2.0")
+	c.line("PRODID", "-//sceptix//Atlus//EN")
+	c.line("CALSCALE", "GREGORIAN")
+	c.line("METHOD", "PUBLISH")
+	c.text("X-WR-CALNAME", "Atlus")
+	c.line("X-PUBLISHED-TTL", "PT1H")
+	c.line("REFRESH-INTERVAL;VALUE=DURATION", "PT1H")
+
+	for _, l := range levels {
+		released := !l.ReleaseTime.After(now)
+		summary := fmt.Sprintf("Atlus level %d", l.LevelID)
+		if released {
+			// names stay hidden until release, like on /schedule
+			summary += ": " + l.Name
+		}
+		description := fmt.Sprintf("Level %d is released at %s.", l.LevelID, l.ReleaseTime.UTC().Format("Jan 2 15:04 MST"))
+		if solved[l.LevelID] {
+			summary = "✓ " + summary
+			description = "You solved this level."
+		}
+
+		c.line("BEGIN", "VEVENT")
+		c.line("UID", fmt.Sprintf("atlus-level%d@%s", l.LevelID, globals.Hostname))
+		c.line("DTSTAMP", icsTime(now))
+		c.line("DTSTART", icsTime(l.ReleaseTime))
+		c.line("DURATION", icsDuration(calendarEventLength))
+		c.text("SUMMARY", summary)
+		c.text("DESCRIPTION", description)
+		c.line("URL", fmt.Sprintf("%s/puzzles/level%d", base, l.LevelID))
+		c.line("TRANSP", "TRANSPARENT")
+		if solved != nil && !solved[l.LevelID] {
+			c.line("BEGIN", "VALARM")
+			c.line("ACTION", "DISPLAY")
+			c.text("DESCRIPTION", fmt.Sprintf("Atlus level %d is released in %d minutes", l.LevelID, int(calendarReminder/time.Minute)))
+			c.line("TRIGGER", "-"+icsDuration(calendarReminder))
+			c.line("END", "VALARM")
+		}
+		c.line("END", "VEVENT")
+	}
+	c.line("END", "VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(c.String()))
+}
+
+// CalendarHandler serves /schedule.ics, every level's release for calendar
+// apps to subscribe to.
+func CalendarHandler(w http.ResponseWriter, r *http.Request) {
+	levels, err := schedule.all(r.Context())
+	if err != nil {
+		log.Printf("error fetching the schedule, %v", err)
+		http.Error(w, "Unable to fetch the schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeCalendar(w, levels, nil)
+}
+
+// PersonalCalendarHandler serves /schedule/{token}.ics, the schedule with
+// the user's solved levels marked and reminders for the rest. Calendar apps
+// can't log in, so the secret token in the url stands in for the session.
+func PersonalCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	token, ok := strings.CutSuffix(r.PathValue("file"), ".ics")
+	if !ok || token == "" {
+		http.Error(w, "Unknown calendar", http.StatusNotFound)
+		return
+	}
+
+	var githubID int64
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT github_id FROM users WHERE calendar_token = $1
+	    `, token).Scan(&githubID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Unknown calendar", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error looking up the calendar token, %v", err)
+		http.Error(w, "Unable to fetch the calendar", http.StatusInternalServerError)
+		return
+	}
+
+	levels, err := schedule.all(ctx)
+	if err != nil {
+		log.Printf("error fetching the schedule, %v", err)
+		http.Error(w, "Unable to fetch the calendar", http.StatusInternalServerError)
+		return
+	}
+	solved, err := solvedLevels(ctx, githubID)
+	if err != nil {
+		log.Printf("error fetching solved levels, %v", err)
+		http.Error(w, "Unable to fetch the calendar", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	writeCalendar(w, levels, solved)
+}
+
+func solvedLevels(ctx context.Context, githubID int64) (map[int]bool, error) {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT level_id FROM submissions
+	    WHERE github_id = $1 AND passed = TRUE
+	    `, githubID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	solved := map[int]bool{}
+	for rows.Next() {
+		var level int
+		if err := rows.Scan(&level); err != nil {
+			return nil, err
+		}
+		solved[level] = true
+	}
+	return solved, rows.Err()
+}
+
+// calendarToken returns the token of a user's personal feed, empty if they
+// haven't created one.
+func calendarToken(ctx context.Context, githubID int64) (string, error) {
+	var token *string
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT calendar_token FROM users WHERE github_id = $1
+	    `, githubID).Scan(&token)
+	if err != nil || token == nil {
+		return "", err
+	}
+	return *token, nil
+}
+
+// CalendarTokenHandler creates a personal feed, replaces its token so the
+// old link stops working, or turns it off.
+func CalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+
+	var token *string
+	if r.FormValue("revoke") != "true" {
+		t := GenerateSessionID()
+		token = &t
+	}
+	_, err := globals.DB.Exec(ctx, `
+	    UPDATE users SET calendar_token = $1
+	    WHERE github_id = $2
+	    `, token, sdata.GithubID)
+	if err != nil {
+		log.Printf("error updating the calendar token, %v", err)
+		http.Error(w, "Unable to update your calendar", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
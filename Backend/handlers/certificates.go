@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// certificate mirrors a certificates row. The details are copied in when
+// the certificate is generated so it keeps saying what was printed.
+type certificate struct {
+	Code         string
+	GithubID     int64
+	Name         string
+	LevelsSolved int
+	TotalLevels  int
+	Rank         int
+	IssuedAt     time.Time
+}
+
+// GenerateCertificates issues a certificate to every user who has solved at
+// least CERTIFICATE_MIN_LEVELS levels, or every level when that isn't set.
+// Running it again refreshes the details but keeps each verification code.
+func GenerateCertificates(ctx context.Context) error {
+	var total int
+	err := globals.DB.QueryRow(ctx, `SELECT COUNT(*) FROM levels`).Scan(&total)
+	if err != nil {
+		return fmt.Errorf("error counting levels: %v", err)
+	}
+
+	required := globals.CertificateMinLevels
+	if required <= 0 {
+		required = total
+	}
+	if required <= 0 {
+		return fmt.Errorf("there are no levels to certify")
+	}
+
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT github_id, username, solved, rank FROM (
+	        SELECT u.github_id, u.username,
+	            (SELECT COUNT(*) FROM submissions s WHERE s.github_id = u.github_id AND s.passed = TRUE) AS solved,
+	            RANK() OVER (ORDER BY COALESCE(SUM(p.points), 0) DESC)::INT AS rank
+	        FROM users u
+	        LEFT JOIN (`+liveTables.Replace(levelPointsQuery(1))+`) p ON p.github_id = u.github_id
+	        GROUP BY u.github_id
+	    ) standings
+	    WHERE solved >= $2
+	    ORDER BY rank
+	    `, levelPoints, required)
+	if err != nil {
+		return fmt.Errorf("error fetching finishers: %v", err)
+	}
+	var certs []certificate
+	for rows.Next() {
+		c := certificate{TotalLevels: total}
+		if err := rows.Scan(&c.GithubID, &c.Name, &c.LevelsSolved, &c.Rank); err != nil {
+			rows.Close()
+			return err
+		}
+		certs = append(certs, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range certs {
+		err := withCode("certificates_pkey", generateVerificationCode, func(code string) error {
+			_, err := globals.DB.Exec(ctx, `
+			    INSERT INTO certificates (code, github_id, name, levels_solved, total_levels, rank)
+			    VALUES ($1, $2, $3, $4, $5, $6)
+			    ON CONFLICT (github_id) DO UPDATE SET
+			        name = EXCLUDED.name,
+			        levels_solved = EXCLUDED.levels_solved,
+			        total_levels = EXCLUDED.total_levels,
+			        rank = EXCLUDED.rank,
+			        issued_at = NOW()
+			    `, code, c.GithubID, c.Name, c.LevelsSolved, c.TotalLevels, c.Rank)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error issuing certificate to %s: %v", c.Name, err)
+		}
+	}
+
+	log.Printf("Issued %d certificates for %d or more levels solved", len(certs), required)
+	return nil
+}
+
+// CertificateHandler downloads the logged in user's certificate.
+func CertificateHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		c, found, err := fetchCertificate(ctx, `github_id = $1`, sdata.GithubID)
+		if err != nil {
+			log.Printf("error fetching the certificate, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+		if !found {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"NoCertificate": true,
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="atlus-certificate-%s.svg"`, c.Code))
+		fmt.Fprint(w, renderCertificate(c))
+	}
+}
+
+// VerifyCertificateHandler is the public page a certificate's verification
+// code points to.
+func VerifyCertificateHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		_, loggedIn := optionalSession(r)
+
+		code := strings.ToUpper(strings.TrimSpace(r.PathValue("code")))
+		c, found, err := fetchCertificate(ctx, `code = $1`, code)
+		if err != nil {
+			log.Printf("error verifying the certificate, %v", err)
+			globals.RenderInfoPage(tpl, w, loggedIn, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		err = tpl.ExecuteTemplate(w, "verify", map[string]any{
+			"LoggedIn":    loggedIn,
+			"Verify":      true,
+			"Code":        code,
+			"Valid":       found,
+			"Certificate": c,
+		})
+		if err != nil {
+			log.Printf("error executing the template, %v", err)
+		}
+	}
+}
+
+// userHasCertificate reports whether the profile should offer a download.
+func userHasCertificate(ctx context.Context, githubID int64) (bool, error) {
+	var found bool
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT EXISTS (SELECT 1 FROM certificates WHERE github_id = $1)
+	    `, githubID).Scan(&found)
+	return found, err
+}
+
+func fetchCertificate(ctx context.Context, where string, arg any) (certificate, bool, error) {
+	var c certificate
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT code, github_id, name, levels_solved, total_levels, rank, issued_at
+	    FROM certificates
+	    WHERE `+where, arg).Scan(&c.Code, &c.GithubID, &c.Name, &c.LevelsSolved, &c.TotalLevels, &c.Rank, &c.IssuedAt)
+	if err == pgx.ErrNoRows {
+		return c, false, nil
+	}
+	return c, err == nil, err
+}
+
+// renderCertificate draws an A4 landscape certificate.
+func renderCertificate(c certificate) string {
+	verifyURL := fmt.Sprintf("http://%s:%s/verify/%s", globals.Hostname, globals.Port, c.Code)
+
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" width="297mm" height="210mm" viewBox="0 0 1123 794">`)
+	b.WriteString(`<rect width="1123" height="794" fill="#0a0706"/>`)
+	b.WriteString(`<rect x="32" y="32" width="1059" height="730" fill="none" stroke="#ffc548" stroke-width="4"/>`)
+	b.WriteString(`<rect x="44" y="44" width="1035" height="706" fill="none" stroke="#ecc67e" stroke-width="1"/>`)
+	b.WriteString(`<g text-anchor="middle" font-family="Georgia,serif" fill="#ffe9ba">`)
+	b.WriteString(`<text x="561" y="170" font-size="56" fill="#ffc548">Atlus</text>`)
+	b.WriteString(`<text x="561" y="230" font-size="24" letter-spacing="6">CERTIFICATE OF COMPLETION</text>`)
+	b.WriteString(`<text x="561" y="320" font-size="20">This certifies that</text>`)
+	fmt.Fprintf(&b, `<text x="561" y="390" font-size="48" fill="#ffc548">%s</text>`, html.EscapeString(c.Name))
+	fmt.Fprintf(&b, `<text x="561" y="460" font-size="20">completed %d of %d levels, finishing at rank #%d</text>`,
+		c.LevelsSolved, c.TotalLevels, c.Rank)
+	fmt.Fprintf(&b, `<text x="561" y="500" font-size="18" fill="#ecc67e">Issued %s</text>`, c.IssuedAt.Format("January 2, 2006"))
+	b.WriteString(`</g>`)
+	b.WriteString(`<g text-anchor="middle" font-family="monospace" fill="#ecc67e" font-size="14">`)
+	fmt.Fprintf(&b, `<text x="561" y="690">Verification code %s</text>`, html.EscapeString(c.Code))
+	fmt.Fprintf(&b, `<text x="561" y="714">%s</text>`, html.EscapeString(verifyURL))
+	b.WriteString(`</g></svg>`)
+	return b.String()
+}
+
+// generateVerificationCode makes codes long enough that they can't be
+// guessed, since anyone can check one at /verify/{code}.
+func generateVerificationCode() string {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Failed to generate verification code")
+	}
+	return base32.StdEncoding.EncodeToString(b)
+}
@@ -2,23 +2,27 @@ package handlers
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"log"
 	"os"
 
 	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joho/godotenv"
 	"github.com/sceptix-club/atlus/Backend/globals"
 )
 
-func InitDB() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file:", err)
-	}
+// querier is satisfied by both the pool and a transaction, for helpers that
+// run either on their own or as part of a larger transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
 
+// InitDB connects to DATABASE_URL and creates the tables. dev drops every
+// table first and fills them from .setup.
+func InitDB(dev bool) {
 	ctx := context.Background()
 	pool, err := pgxpool.New(ctx, os.Getenv("DATABASE_URL"))
 	if err != nil {
@@ -30,12 +34,10 @@ func InitDB() {
 		log.Fatalf("Query test failed: %v", err)
 	}
 
-	forkPtr := flag.Bool("dev", false, "DEV MODE : to truncate all db tables, on startup")
-	flag.Parse()
-	if *forkPtr {
-		pool.Exec(ctx, "drop table users, sessions, submissions, levels;")
+	if dev {
+		pool.Exec(ctx, "drop table if exists users, sessions, submissions, levels, private_boards, private_board_members, leaderboard_freeze, frozen_users, frozen_submissions, teams, team_members, user_badges, certificates, hint_reveals, frozen_hint_reveals, writeups, writeup_votes, discussion_posts, release_jobs, leaderboard_snapshots, announcements, announcement_dismissals;")
 		fmt.Println("dropped all tables!")
-		schema, err := os.ReadFile("schema.sql")
+		schema, err := globals.Assets.ReadFile("schema.sql")
 		if err != nil {
 			log.Fatalf("Unable to find schema.sql, %v", err)
 		}
@@ -55,7 +57,7 @@ func InitDB() {
 		}
 	} else {
 
-		schema, err := os.ReadFile("schema.sql")
+		schema, err := globals.Assets.ReadFile("schema.sql")
 		if err != nil {
 			log.Fatalf("Unable to find schema.sql, %v", err)
 		}
@@ -152,42 +154,22 @@ func updateSessionToken(ctx context.Context, githubID int64, newSessionID string
 func getSessionData(ctx context.Context, sessionID string) (globals.SessionData, error) {
 	var sdata globals.SessionData
 
-	var fetchErr error
-
-	done := make(chan error, 1)
-
-	go func() {
-		err := globals.DB.QueryRow(ctx, `
-		    SELECT u.github_id, u.input_id, u.current_level, u.username, u.github_url, u.avatar, u.email, u.streak, u.created_at
-		    FROM users u
-		    JOIN sessions s on s.github_id = u.github_id
-		    WHERE s.session_id = $1 AND s.expires_at > NOW()
-		    `, sessionID).Scan(&sdata.GithubID, &sdata.InputID, &sdata.CurrentLevel, &sdata.Username,
-			&sdata.GithubUrl, &sdata.Avatar, &sdata.Email, &sdata.Streak, &sdata.CreatedAt)
-		done <- err
-	}()
-
 	err := globals.DB.QueryRow(ctx, `
-        SELECT level_id FROM levels
-        WHERE release_time > NOW()
-        ORDER BY release_time
-        LIMIT 1;
-        `).Scan(&sdata.NextReleaseLevel)
-
+	    SELECT u.github_id, u.input_id, u.current_level, u.username, u.github_url, u.avatar, u.email, u.streak, u.longest_streak, u.first_try_streak, u.is_admin, u.created_at
+	    FROM users u
+	    JOIN sessions s on s.github_id = u.github_id
+	    WHERE s.session_id = $1 AND s.expires_at > NOW()
+	    `, sessionID).Scan(&sdata.GithubID, &sdata.InputID, &sdata.CurrentLevel, &sdata.Username,
+		&sdata.GithubUrl, &sdata.Avatar, &sdata.Email, &sdata.Streak, &sdata.LongestStreak, &sdata.FirstTryStreak, &sdata.IsAdmin, &sdata.CreatedAt)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			// TODO: this is means there is no new level scheduled to release => end of the event, need to handle it later
-			sdata.NextReleaseLevel = 100
-		} else {
-			log.Printf("Error fetching next release level data :%v\n", err)
-			return globals.SessionData{}, err
-		}
+		log.Printf("error fetching session data, %v", err)
+		return globals.SessionData{}, err
 	}
 
-	fetchErr = <-done
-	if fetchErr != nil {
-		log.Printf("error fetching session data, %v", fetchErr)
-		return globals.SessionData{}, fetchErr
+	sdata.NextReleaseLevel, err = schedule.nextReleaseLevel(ctx)
+	if err != nil {
+		log.Printf("Error fetching next release level data :%v\n", err)
+		return globals.SessionData{}, err
 	}
 
 	return sdata, nil
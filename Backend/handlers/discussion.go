@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// longest discussion post accepted, in bytes
+const postMaxLength = 5000
+
+type discussionPost struct {
+	PostID    int64
+	GithubID  int64
+	Username  string
+	Body      template.HTML
+	Hidden    bool
+	CreatedAt time.Time
+	Replies   []discussionPost
+}
+
+// discussionLevel resolves the level of a discussion route. Only users who
+// passed the level, and admins so they can moderate, get through.
+func discussionLevel(ctx context.Context, tpl *template.Template, w http.ResponseWriter, r *http.Request) (int, bool) {
+	level, err := getLevelParam(r.PathValue("slug"))
+	if err != nil {
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"InvalidRequest": true,
+		})
+		return 0, false
+	}
+
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+	if sdata.IsAdmin {
+		return level, true
+	}
+
+	passed, err := passedLevel(ctx, sdata.GithubID, level)
+	if err != nil {
+		log.Printf("error checking the submission, %v", err)
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"Unexpected": true,
+		})
+		return 0, false
+	}
+	if !passed {
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"DiscussionLocked": true,
+			"Level":            level,
+		})
+		return 0, false
+	}
+	return level, true
+}
+
+// DiscussionHandler shows the threads of a level, oldest first.
+func DiscussionHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		level, ok := discussionLevel(ctx, tpl, w, r)
+		if !ok {
+			return
+		}
+
+		threads, err := levelThreads(ctx, level, sdata.IsAdmin)
+		if err != nil {
+			log.Printf("error fetching the discussion, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		err = tpl.ExecuteTemplate(w, "discussion", map[string]any{
+			"LoggedIn":   true,
+			"Discussion": true,
+			"Slug":       fmt.Sprintf("level%d", level),
+			"LevelId":    level,
+			"Threads":    threads,
+			"IsAdmin":    sdata.IsAdmin,
+			"MaxLength":  postMaxLength,
+		})
+		if err != nil {
+			log.Printf("error executing the template, %v", err)
+		}
+	}
+}
+
+// DiscussionPostHandler adds a post, or a reply when parent is set. Replies
+// always attach to the top post of a thread.
+func DiscussionPostHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		level, ok := discussionLevel(ctx, tpl, w, r)
+		if !ok {
+			return
+		}
+
+		body := strings.TrimSpace(r.FormValue("body"))
+		if body == "" || len(body) > postMaxLength {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		var parent *int64
+		if p := r.FormValue("parent"); p != "" {
+			id, err := strconv.ParseInt(p, 10, 64)
+			if err != nil {
+				globals.RenderInfoPage(tpl, w, true, map[string]any{
+					"InvalidRequest": true,
+				})
+				return
+			}
+			parent = &id
+		}
+
+		var postID int64
+		err := globals.DB.QueryRow(ctx, `
+		    INSERT INTO discussion_posts (level_id, github_id, parent_id, body)
+		    SELECT $1::INT, $2::INT, $3::INT, $4::TEXT
+		    WHERE $3::INT IS NULL OR EXISTS (
+		        SELECT 1 FROM discussion_posts
+		        WHERE post_id = $3 AND level_id = $1 AND parent_id IS NULL
+		    )
+		    RETURNING post_id
+		    `, level, sdata.GithubID, parent, body).Scan(&postID)
+		if err == pgx.ErrNoRows {
+			// replying to a post that isn't a thread on this level
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("error posting to the discussion, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/discussion/level%d#post-%d", level, postID), http.StatusSeeOther)
+	}
+}
+
+// HidePostHandler toggles whether a post is hidden from everyone but
+// admins. Hiding a thread's top post hides its replies too.
+func HidePostHandler(w http.ResponseWriter, r *http.Request) {
+	moderatePost(w, r, `UPDATE discussion_posts SET hidden = NOT hidden WHERE post_id = $1 AND level_id = $2`)
+}
+
+// DeletePostHandler removes a post along with its replies.
+func DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	moderatePost(w, r, `DELETE FROM discussion_posts WHERE post_id = $1 AND level_id = $2`)
+}
+
+func moderatePost(w http.ResponseWriter, r *http.Request, query string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+
+	level, err := getLevelParam(r.PathValue("slug"))
+	if err != nil {
+		http.Error(w, "Invalid url request", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid url request", http.StatusBadRequest)
+		return
+	}
+
+	_, err = globals.DB.Exec(ctx, query, id, level)
+	if err != nil {
+		log.Printf("error moderating the discussion, %v", err)
+		http.Error(w, "Unable to moderate the post", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s moderated post %d on level %d", sdata.Username, id, level)
+	http.Redirect(w, r, fmt.Sprintf("/discussion/level%d", level), http.StatusSeeOther)
+}
+
+// levelThreads groups a level's posts into threads. Hidden posts are left
+// out unless an admin is looking.
+func levelThreads(ctx context.Context, level int, admin bool) ([]discussionPost, error) {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT p.post_id, p.parent_id, p.github_id, u.username, p.body, p.hidden, p.created_at
+	    FROM discussion_posts p
+	    JOIN users u ON u.github_id = p.github_id
+	    WHERE p.level_id = $1
+	    ORDER BY p.created_at, p.post_id
+	    `, level)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var threads []discussionPost
+	index := map[int64]int{}
+	for rows.Next() {
+		var p discussionPost
+		var parent *int64
+		var body string
+		if err := rows.Scan(&p.PostID, &parent, &p.GithubID, &p.Username, &body, &p.Hidden, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		if p.Hidden && !admin {
+			continue
+		}
+		p.Body, err = renderUserMarkdown([]byte(body))
+		if err != nil {
+			return nil, err
+		}
+
+		if parent == nil {
+			index[p.PostID] = len(threads)
+			threads = append(threads, p)
+		} else if i, ok := index[*parent]; ok {
+			threads[i].Replies = append(threads[i].Replies, p)
+		}
+	}
+	return threads, rows.Err()
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// most entries the feed carries, feed readers only need the recent ones
+const feedMaxEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Link     atomLink     `xml:"link"`
+	Category atomCategory `xml:"category"`
+	Content  atomContent  `xml:"content"`
+
+	at time.Time
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// FeedHandler serves /feed.atom, an entry for every released level and
+// every announcement meant for everyone. Levels and announcements show up
+// once they're out and not before, so nothing leaks early.
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	base := fmt.Sprintf("http://%s:%s", globals.Hostname, globals.Port)
+
+	levels, err := schedule.all(ctx)
+	if err != nil {
+		log.Printf("error fetching the schedule, %v", err)
+		http.Error(w, "Unable to fetch the feed", http.StatusInternalServerError)
+		return
+	}
+	announcements, err := fetchAnnouncements(ctx)
+	if err != nil {
+		log.Printf("error fetching announcements, %v", err)
+		http.Error(w, "Unable to fetch the feed", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	var entries []atomEntry
+	for _, l := range levels {
+		if l.ReleaseTime.After(now) {
+			continue
+		}
+		link := fmt.Sprintf("%s/puzzles/level%d", base, l.LevelID)
+		entries = append(entries, atomEntry{
+			ID:       link,
+			Title:    l.Name,
+			Updated:  atomTime(l.ReleaseTime),
+			Link:     atomLink{Href: link},
+			Category: atomCategory{Term: "release"},
+			Content:  atomContent{Type: "text", Body: fmt.Sprintf("Level %d, %s, is out!", l.LevelID, l.Name)},
+			at:       l.ReleaseTime,
+		})
+	}
+	for _, a := range announcements {
+		// scheduled ones aren't out yet, level ones are only for users on it
+		if !a.Public() || a.StartsAt.After(now) {
+			continue
+		}
+		entries = append(entries, atomEntry{
+			ID:       fmt.Sprintf("%s/feed.atom#announcement-%d", base, a.ID),
+			Title:    a.Title,
+			Updated:  atomTime(a.StartsAt),
+			Link:     atomLink{Href: base + "/"},
+			Category: atomCategory{Term: "announcement"},
+			Content:  atomContent{Type: "html", Body: string(a.HTML)},
+			at:       a.StartsAt,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].at.After(entries[j].at) })
+	if len(entries) > feedMaxEntries {
+		entries = entries[:feedMaxEntries]
+	}
+
+	var updated time.Time
+	if len(entries) > 0 {
+		updated = entries[0].at
+	}
+
+	feed := atomFeed{
+		ID:      base + "/feed.atom",
+		Title:   "Atlus",
+		Updated: atomTime(updated),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + "/feed.atom"},
+			{Rel: "alternate", Type: "text/html", Href: base + "/"},
+		},
+		Author:  atomAuthor{Name: "sceptix"},
+		Entries: entries,
+	}
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Printf("error encoding the feed, %v", err)
+		http.Error(w, "Unable to fetch the feed", http.StatusInternalServerError)
+		return
+	}
+	body = append([]byte(xml.Header), body...)
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	// only the ETag, the newest entry's time doesn't move when an announcement
+	// is deleted or backdated, so If-Modified-Since would answer with a stale feed
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, no-cache")
+	if notModified(r, etag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write(body)
+}
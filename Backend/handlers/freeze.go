@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// how often the freeze watcher checks for a due snapshot or reveal step
+const freezeWatchInterval = 2 * time.Second
+
+// leaderboardFreeze mirrors the single leaderboard_freeze row.
+type leaderboardFreeze struct {
+	FrozenAt        time.Time
+	RevealSteps     int
+	RevealStep      int
+	RevealInterval  time.Duration
+	RevealStartedAt *time.Time
+	RevealNextAt    *time.Time
+	RevealedAt      *time.Time
+}
+
+// Revealing reports whether a stepwise reveal is in progress.
+func (f leaderboardFreeze) Revealing() bool {
+	return f.RevealStartedAt != nil && f.RevealedAt == nil
+}
+
+var (
+	liveTables   = strings.NewReplacer("{users}", "users", "{submissions}", "submissions", "{hint_reveals}", "hint_reveals")
+	frozenTables = strings.NewReplacer("{users}", "frozen_users", "{submissions}", "frozen_submissions", "{hint_reveals}", "frozen_hint_reveals")
+)
+
+// leaderboardTables points the {users}, {submissions} and {hint_reveals}
+// placeholders of a leaderboard query at either the live tables or the
+// freeze snapshot.
+func leaderboardTables(query string, frozen bool) string {
+	if frozen {
+		return frozenTables.Replace(query)
+	}
+	return liveTables.Replace(query)
+}
+
+// leaderboardFrozen reports whether leaderboards should be served from the
+// snapshot. The snapshot is taken here if the watcher hasn't got to it yet,
+// and errors keep the board frozen rather than leak live standings.
+func leaderboardFrozen(ctx context.Context) bool {
+	if globals.FreezeAt.IsZero() || time.Now().Before(globals.FreezeAt) {
+		return false
+	}
+
+	freeze, found, err := fetchFreeze(ctx)
+	if err != nil {
+		log.Printf("error fetching the leaderboard freeze, %v", err)
+		return true
+	}
+	if !found {
+		if err := takeFreezeSnapshot(ctx); err != nil {
+			log.Printf("error taking the leaderboard snapshot, %v", err)
+		}
+		return true
+	}
+	return freeze.RevealedAt == nil
+}
+
+// WatchLeaderboardFreeze takes the snapshot once LEADERBOARD_FREEZE_AT
+// passes and advances stepwise reveals. It returns straight away when no
+// freeze is configured.
+func WatchLeaderboardFreeze(ctx context.Context) {
+	if globals.FreezeAt.IsZero() {
+		return
+	}
+
+	ticker := time.NewTicker(freezeWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if time.Now().Before(globals.FreezeAt) {
+			continue
+		}
+
+		freeze, found, err := fetchFreeze(ctx)
+		if err != nil {
+			log.Printf("error fetching the leaderboard freeze, %v", err)
+			continue
+		}
+		if !found {
+			if err := takeFreezeSnapshot(ctx); err != nil {
+				log.Printf("error taking the leaderboard snapshot, %v", err)
+			}
+			continue
+		}
+		if freeze.Revealing() && freeze.RevealNextAt != nil && !time.Now().Before(*freeze.RevealNextAt) {
+			if err := revealStep(ctx, freeze); err != nil {
+				log.Printf("error revealing the leaderboard, %v", err)
+			}
+		}
+	}
+}
+
+func fetchFreeze(ctx context.Context) (leaderboardFreeze, bool, error) {
+	var f leaderboardFreeze
+	var interval *time.Duration
+
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT frozen_at, reveal_steps, reveal_step, reveal_interval,
+	        reveal_started_at, reveal_next_at, revealed_at
+	    FROM leaderboard_freeze
+	    `).Scan(&f.FrozenAt, &f.RevealSteps, &f.RevealStep, &interval,
+		&f.RevealStartedAt, &f.RevealNextAt, &f.RevealedAt)
+	if err == pgx.ErrNoRows {
+		return f, false, nil
+	}
+	if err != nil {
+		return f, false, err
+	}
+	if interval != nil {
+		f.RevealInterval = *interval
+	}
+	return f, true, nil
+}
+
+// takeFreezeSnapshot copies the standings into the frozen tables. Only the
+// instance that manages to insert the freeze row does the copy.
+func takeFreezeSnapshot(ctx context.Context) error {
+	tx, err := globals.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+	    INSERT INTO leaderboard_freeze (frozen_at)
+	    VALUES ($1)
+	    ON CONFLICT DO NOTHING
+	    `, globals.FreezeAt.UTC())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM frozen_users`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM frozen_submissions`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM frozen_hint_reveals`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+	    INSERT INTO frozen_users (github_id, username, github_url, current_level, streak)
+	    SELECT github_id, username, github_url, current_level, streak FROM users
+	    `)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+	    INSERT INTO frozen_submissions (github_id, username, level_id, last_submission, time_taken, attempts, passed)
+	    SELECT github_id, username, level_id, last_submission, time_taken, attempts, passed FROM submissions
+	    `)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+	    INSERT INTO frozen_hint_reveals (github_id, level_id, tier, cost, penalty, revealed_at)
+	    SELECT github_id, level_id, tier, cost, penalty, revealed_at FROM hint_reveals
+	    `)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Leaderboards frozen as of %v", globals.FreezeAt)
+	return tx.Commit(ctx)
+}
+
+// revealStep moves the snapshot one step closer to the live standings. Each
+// step reveals the users whose last submission falls before a cutoff that
+// walks from the freeze to when the reveal started; the final step lifts the
+// freeze altogether.
+func revealStep(ctx context.Context, f leaderboardFreeze) error {
+	next := f.RevealStep + 1
+	span := f.RevealStartedAt.Sub(f.FrozenAt)
+	cutoff := f.FrozenAt.Add(span * time.Duration(next) / time.Duration(f.RevealSteps))
+
+	tx, err := globals.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// claim the step so concurrent instances don't both apply it
+	tag, err := tx.Exec(ctx, `
+	    UPDATE leaderboard_freeze SET
+	        reveal_step = $1,
+	        reveal_next_at = NOW() + reveal_interval,
+	        revealed_at = CASE WHEN $1 >= reveal_steps THEN NOW() END
+	    WHERE reveal_step = $2 AND revealed_at IS NULL
+	    `, next, f.RevealStep)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	if next < f.RevealSteps {
+		_, err = tx.Exec(ctx, `
+		    INSERT INTO frozen_users (github_id, username, github_url, current_level, streak)
+		    SELECT github_id, username, github_url, current_level, streak FROM users
+		    WHERE github_id IN (
+		        SELECT github_id FROM submissions
+		        GROUP BY github_id
+		        HAVING MAX(last_submission) <= $1
+		    )
+		    ON CONFLICT (github_id) DO UPDATE SET
+		        username = EXCLUDED.username,
+		        github_url = EXCLUDED.github_url,
+		        current_level = EXCLUDED.current_level,
+		        streak = EXCLUDED.streak
+		    `, cutoff.UTC())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+		    INSERT INTO frozen_submissions (github_id, username, level_id, last_submission, time_taken, attempts, passed)
+		    SELECT github_id, username, level_id, last_submission, time_taken, attempts, passed FROM submissions
+		    WHERE github_id IN (
+		        SELECT github_id FROM submissions
+		        GROUP BY github_id
+		        HAVING MAX(last_submission) <= $1
+		    )
+		    ON CONFLICT (github_id, level_id) DO UPDATE SET
+		        username = EXCLUDED.username,
+		        last_submission = EXCLUDED.last_submission,
+		        time_taken = EXCLUDED.time_taken,
+		        attempts = EXCLUDED.attempts,
+		        passed = EXCLUDED.passed
+		    `, cutoff.UTC())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+		    INSERT INTO frozen_hint_reveals (github_id, level_id, tier, cost, penalty, revealed_at)
+		    SELECT github_id, level_id, tier, cost, penalty, revealed_at FROM hint_reveals
+		    WHERE github_id IN (
+		        SELECT github_id FROM submissions
+		        GROUP BY github_id
+		        HAVING MAX(last_submission) <= $1
+		    )
+		    ON CONFLICT (github_id, level_id, tier) DO NOTHING
+		    `, cutoff.UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Leaderboard reveal step %d/%d, cutoff %v", next, f.RevealSteps, cutoff)
+	return tx.Commit(ctx)
+}
+
+// RevealLeaderboardHandler unfreezes the leaderboards. With steps > 1 the
+// snapshot catches up with the live standings over that many steps, one every
+// interval seconds, instead of all at once.
+func RevealLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+
+	steps, err := strconv.Atoi(r.FormValue("steps"))
+	if err != nil || steps < 1 {
+		steps = 1
+	}
+	interval, err := strconv.Atoi(r.FormValue("interval"))
+	if err != nil || interval < 1 {
+		interval = 10
+	}
+
+	if steps == 1 {
+		_, err = globals.DB.Exec(ctx, `
+		    UPDATE leaderboard_freeze SET revealed_at = NOW()
+		    WHERE revealed_at IS NULL
+		    `)
+	} else {
+		_, err = globals.DB.Exec(ctx, `
+		    UPDATE leaderboard_freeze SET
+		        reveal_steps = $1,
+		        reveal_step = 0,
+		        reveal_interval = $2::INT * INTERVAL '1 second',
+		        reveal_started_at = NOW(),
+		        reveal_next_at = NOW()
+		    WHERE revealed_at IS NULL AND reveal_started_at IS NULL
+		    `, steps, interval)
+	}
+	if err != nil {
+		log.Printf("error revealing the leaderboard, %v", err)
+		http.Error(w, "Unable to reveal the leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s revealed the leaderboard in %d steps", sdata.Username, steps)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// hint is one tier of levelN/hints.json. A tier with neither unlock
+// condition can be revealed straight away, otherwise it unlocks once either
+// condition is met. Tiers are revealed in order.
+type hint struct {
+	Text           string       `json:"text"`
+	UnlockAfter    hintDuration `json:"unlock_after"`    // after the level's release
+	UnlockAttempts int          `json:"unlock_attempts"` // wrong answers
+	Cost           int          `json:"cost"`            // points taken off the level
+	Penalty        hintDuration `json:"penalty"`         // added to the time ranked on
+}
+
+// hintDuration reads durations like "2h30m" from json.
+type hintDuration time.Duration
+
+func (d *hintDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = hintDuration(parsed)
+	return nil
+}
+
+// hintProgress is what decides which hints a user can see and reveal.
+type hintProgress struct {
+	Released time.Time
+	Attempts int
+	Passed   bool
+	Revealed int // tiers revealed so far
+}
+
+// hintView is a hint as shown on the level page.
+type hintView struct {
+	Tier           int
+	Revealed       bool
+	Unlocked       bool
+	Text           template.HTML
+	Cost           int
+	Penalty        time.Duration
+	UnlockAt       time.Time
+	UnlockAttempts int
+}
+
+type hintUsage struct {
+	LevelID int
+	Tier    int
+	Users   int
+	Points  int
+}
+
+func (h hint) unlocked(p hintProgress, now time.Time) bool {
+	if h.UnlockAfter == 0 && h.UnlockAttempts == 0 {
+		return true
+	}
+	if h.UnlockAfter > 0 && !now.Before(p.Released.Add(time.Duration(h.UnlockAfter))) {
+		return true
+	}
+	return h.UnlockAttempts > 0 && p.Attempts >= h.UnlockAttempts
+}
+
+// loadHints reads a level's hints, levels without a hints file have none.
+func loadHints(level int) ([]hint, error) {
+	b, err := globals.Puzzles.ReadFile(fmt.Sprintf("level%d/hints.json", level))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hints []hint
+	if err := json.Unmarshal(b, &hints); err != nil {
+		return nil, fmt.Errorf("error parsing hints for level %d: %v", level, err)
+	}
+	return hints, nil
+}
+
+func fetchHintProgress(ctx context.Context, githubID int64, level int) (hintProgress, error) {
+	var p hintProgress
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT l.release_time, COALESCE(s.attempts, 0), COALESCE(s.passed, FALSE),
+	        (SELECT COUNT(*) FROM hint_reveals h WHERE h.github_id = $1 AND h.level_id = l.level_id)
+	    FROM levels l
+	    LEFT JOIN submissions s ON s.level_id = l.level_id AND s.github_id = $1
+	    WHERE l.level_id = $2
+	    `, githubID, level).Scan(&p.Released, &p.Attempts, &p.Passed, &p.Revealed)
+	return p, err
+}
+
+// levelHints lists the revealed hints of a level and the next one in line.
+// Tiers past the next one stay hidden.
+func levelHints(ctx context.Context, githubID int64, level int) ([]hintView, error) {
+	hints, err := loadHints(level)
+	if err != nil || len(hints) == 0 {
+		return nil, err
+	}
+
+	p, err := fetchHintProgress(ctx, githubID, level)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var views []hintView
+	for i, h := range hints {
+		// solved levels only show what was revealed
+		if i > p.Revealed || (i == p.Revealed && p.Passed) {
+			break
+		}
+
+		v := hintView{
+			Tier:           i + 1,
+			Revealed:       i < p.Revealed,
+			Unlocked:       h.unlocked(p, now),
+			Cost:           h.Cost,
+			Penalty:        time.Duration(h.Penalty),
+			UnlockAttempts: h.UnlockAttempts,
+		}
+		if h.UnlockAfter > 0 {
+			v.UnlockAt = p.Released.Add(time.Duration(h.UnlockAfter))
+		}
+
+		if v.Revealed {
+			v.Text, err = renderMarkdown([]byte(h.Text))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		views = append(views, v)
+	}
+	return views, nil
+}
+
+// RevealHintHandler reveals the next hint tier of a level, recording its
+// cost and penalty so later changes to hints.json don't alter scores.
+func RevealHintHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+
+		level, err := getLevelParam(r.PathValue("slug"))
+		if err != nil {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+		puzzleURL := fmt.Sprintf("/puzzles/level%d", level)
+
+		if sdata.NextReleaseLevel <= level || level > sdata.CurrentLevel {
+			http.Redirect(w, r, puzzleURL, http.StatusSeeOther)
+			return
+		}
+
+		hints, err := loadHints(level)
+		if err != nil {
+			log.Printf("error loading hints, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+		tier, err := strconv.Atoi(r.FormValue("tier"))
+		if err != nil || tier < 1 || tier > len(hints) {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		p, err := fetchHintProgress(ctx, sdata.GithubID, level)
+		if err != nil {
+			log.Printf("error fetching hint progress, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		h := hints[tier-1]
+		if p.Passed || tier != p.Revealed+1 || !h.unlocked(p, time.Now()) {
+			http.Redirect(w, r, puzzleURL, http.StatusSeeOther)
+			return
+		}
+
+		_, err = globals.DB.Exec(ctx, `
+		    INSERT INTO hint_reveals (github_id, level_id, tier, cost, penalty)
+		    VALUES ($1, $2, $3, $4, $5)
+		    ON CONFLICT DO NOTHING
+		    `, sdata.GithubID, level, tier, h.Cost, time.Duration(h.Penalty))
+		if err != nil {
+			log.Printf("error revealing hint, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		log.Printf("%s revealed hint %d of level %d", sdata.Username, tier, level)
+		http.Redirect(w, r, puzzleURL+"#hints", http.StatusSeeOther)
+	}
+}
+
+// fetchHintUsage counts reveals per level and tier for the admin page.
+func fetchHintUsage(ctx context.Context) ([]hintUsage, error) {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT level_id, tier, COUNT(*), COALESCE(SUM(cost), 0)
+	    FROM hint_reveals
+	    GROUP BY level_id, tier
+	    ORDER BY level_id, tier
+	    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []hintUsage
+	for rows.Next() {
+		var u hintUsage
+		if err := rows.Scan(&u.LevelID, &u.Tier, &u.Users, &u.Points); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
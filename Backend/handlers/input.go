@@ -8,7 +8,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 
@@ -34,7 +33,7 @@ func InputHandler(w http.ResponseWriter, r *http.Request) {
 	sdata := ctx.Value("sessionData").(globals.SessionData)
 
 	if sdata.NextReleaseLevel <= level {
-		http.Error(w, "Level is not released yet!\nPlease do not request this endpoint repeatedly", http.StatusForbidden)
+		http.Error(w, "Level is not released yet!\nPlease do not request this endpoint repeatedly, /api/schedule has the release times", http.StatusForbidden)
 		return
 	}
 
@@ -43,23 +42,24 @@ func InputHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fileName := fmt.Sprintf("./puzzles/%s/problem_set/%d.json", slug, sdata.InputID)
-	file, err := os.Open(fileName)
+	problemSet, err := readProblemSet(level, sdata.InputID)
 	if err != nil {
-		log.Printf("File not found : %v\n", err)
+		log.Printf("error reading the input, %v", err)
 		http.Error(w, "Something weird happened! :( please report this to sceptix@sjec.ac.in", http.StatusForbidden)
 		return
 	}
+	io.Copy(w, bytes.NewBuffer([]byte(problemSet.Input)))
+}
 
-	defer file.Close()
-	b, err := io.ReadAll(file)
+// readProblemSet reads the input and answer a user gets for a level.
+func readProblemSet(level int, inputID int) (globals.ProblemSet, error) {
+	var problemSet globals.ProblemSet
+	b, err := globals.Puzzles.ReadFile(fmt.Sprintf("level%d/problem_set/%d.json", level, inputID))
 	if err != nil {
-		log.Printf("can't read the file")
-		return
+		return problemSet, err
 	}
-	var problemSet globals.ProblemSet
-	json.Unmarshal(b, &problemSet)
-	io.Copy(w, bytes.NewBuffer([]byte(problemSet.Input)))
+	err = json.Unmarshal(b, &problemSet)
+	return problemSet, err
 }
 
 func getLevelParam(slug string) (int, error) {
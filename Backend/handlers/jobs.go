@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+const (
+	// longest the scheduler sleeps before looking for work again, which is
+	// also how soon it notices release times changed by an admin
+	schedulerPoll = 30 * time.Second
+
+	// levels released longer ago than this don't get their jobs run, so the
+	// first deploy doesn't replay every past release
+	jobCatchUp = time.Hour
+
+	// wait before retrying a failed job, times the attempts so far
+	jobRetryDelay = 30 * time.Second
+)
+
+// releaseJob runs once for every level, as soon as its release_time passes.
+// Local jobs refresh in-memory state, so they run in every instance and are
+// only tracked in memory.
+type releaseJob struct {
+	Name     string
+	Attempts int // tries before giving up
+	Local    bool
+	Run      func(ctx context.Context, level int) error
+}
+
+var releaseJobs []releaseJob
+
+type localJobKey struct {
+	Job   string
+	Level int
+}
+
+// the local job runs of this instance
+var (
+	localJobsMu sync.Mutex
+	localJobs   = map[localJobKey]*releaseJobRun{}
+)
+
+func registerJob(j releaseJob) {
+	releaseJobs = append(releaseJobs, j)
+}
+
+func init() {
+	registerJob(releaseJob{
+		Name:     "warm-puzzles",
+		Attempts: 10,
+		Local:    true,
+		Run:      warmPuzzles,
+	})
+	registerJob(releaseJob{
+		Name:     "snapshot-leaderboard",
+		Attempts: 3,
+		Run:      snapshotLeaderboard,
+	})
+	registerJob(releaseJob{
+		Name:     "notify",
+		Attempts: 5,
+		Run:      notifyRelease,
+	})
+}
+
+// releaseJobRun mirrors a release_jobs row, or a local job run.
+type releaseJobRun struct {
+	Job           string
+	LevelID       int
+	Attempts      int
+	MaxAttempts   int
+	LastError     string
+	NextAttemptAt time.Time
+	FinishedAt    *time.Time
+	Local         bool
+}
+
+func (j releaseJobRun) Status() string {
+	switch {
+	case j.FinishedAt != nil:
+		return "done"
+	case j.Attempts >= j.MaxAttempts:
+		return "gave up"
+	case j.Attempts > 0:
+		return "retrying"
+	}
+	return "pending"
+}
+
+func releaseJobNames() []string {
+	names := make([]string, len(releaseJobs))
+	for i, j := range releaseJobs {
+		names[i] = j.Name
+		if j.Local {
+			names[i] += " (every instance)"
+		}
+	}
+	return names
+}
+
+func jobAttempts(name string) int {
+	for _, j := range releaseJobs {
+		if j.Name == name {
+			return j.Attempts
+		}
+	}
+	return 0
+}
+
+// RunScheduler runs the release jobs of every level as its release_time
+// passes. Each job and level is guarded by an advisory lock and recorded in
+// release_jobs, so running several instances does the work once. Local jobs
+// are the exception.
+//
+// Every pass also refreshes the in-memory release schedule, so changes to
+// levels are picked up within schedulerPoll, or right away on SIGHUP.
+func RunScheduler(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		if err := schedule.refresh(ctx); err != nil {
+			log.Printf("error refreshing the release schedule, %v", err)
+		}
+		if err := runDueJobs(ctx); err != nil {
+			log.Printf("error running release jobs, %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-time.After(nextRelease(ctx)):
+		}
+	}
+}
+
+// nextRelease is how long to sleep: until the next release, or
+// schedulerPoll if that comes first.
+func nextRelease(ctx context.Context) time.Duration {
+	now := time.Now()
+	next, ok, err := schedule.upcoming(ctx, now)
+	if err != nil || !ok {
+		return schedulerPoll
+	}
+	return min(schedulerPoll, next.ReleaseTime.Sub(now))
+}
+
+// runDueJobs runs the jobs of levels released within jobCatchUp, along with
+// any older ones that haven't finished.
+func runDueJobs(ctx context.Context) error {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT level_id FROM levels l
+	    WHERE release_time <= NOW() AND (
+	        release_time > NOW() - $1::INTERVAL
+	        OR EXISTS (
+	            SELECT 1 FROM release_jobs j
+	            WHERE j.level_id = l.level_id AND j.finished_at IS NULL
+	        )
+	    )
+	    ORDER BY level_id
+	    `, jobCatchUp)
+	if err != nil {
+		return err
+	}
+	var levels []int
+	for rows.Next() {
+		var level int
+		if err := rows.Scan(&level); err != nil {
+			rows.Close()
+			return err
+		}
+		levels = append(levels, level)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, level := range levels {
+		for _, job := range releaseJobs {
+			if job.Local {
+				runLocalJob(ctx, job, level)
+				continue
+			}
+			if err := runJob(ctx, job, level); err != nil {
+				log.Printf("error running %s for level %d, %v", job.Name, level, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runJob makes one attempt at a job unless it's finished, out of attempts,
+// waiting to retry or held by another instance. Errors from the job itself
+// are recorded rather than returned.
+func runJob(ctx context.Context, job releaseJob, level int) error {
+	conn, err := globals.DB.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	lockKey := jobLockKey(job.Name)
+	var locked bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1::INT, $2::INT)`, lockKey, level).Scan(&locked)
+	if err != nil || !locked {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1::INT, $2::INT)`, lockKey, level)
+
+	var attempts int
+	var finished, due bool
+	err = conn.QueryRow(ctx, `
+	    SELECT attempts, finished_at IS NOT NULL, next_attempt_at <= NOW()
+	    FROM release_jobs
+	    WHERE job = $1 AND level_id = $2
+	    `, job.Name, level).Scan(&attempts, &finished, &due)
+	if err == pgx.ErrNoRows {
+		due = true
+	} else if err != nil {
+		return err
+	}
+	if finished || !due || attempts >= job.Attempts {
+		return nil
+	}
+
+	runErr := job.Run(ctx, level)
+	attempts++
+	if runErr != nil {
+		log.Printf("%s for level %d failed, attempt %d of %d, %v", job.Name, level, attempts, job.Attempts, runErr)
+		_, err = conn.Exec(ctx, `
+		    INSERT INTO release_jobs (job, level_id, attempts, last_error, next_attempt_at)
+		    VALUES ($1, $2, $3, $4, NOW() + $5::INTERVAL)
+		    ON CONFLICT (job, level_id) DO UPDATE SET
+		        attempts = EXCLUDED.attempts,
+		        last_error = EXCLUDED.last_error,
+		        next_attempt_at = EXCLUDED.next_attempt_at
+		    `, job.Name, level, attempts, runErr.Error(), jobRetryDelay*time.Duration(attempts))
+		return err
+	}
+
+	log.Printf("%s for level %d done", job.Name, level)
+	_, err = conn.Exec(ctx, `
+	    INSERT INTO release_jobs (job, level_id, attempts, finished_at)
+	    VALUES ($1, $2, $3, NOW())
+	    ON CONFLICT (job, level_id) DO UPDATE SET
+	        attempts = EXCLUDED.attempts,
+	        last_error = '',
+	        finished_at = EXCLUDED.finished_at
+	    `, job.Name, level, attempts)
+	return err
+}
+
+// runLocalJob is runJob for local jobs, with the attempts kept in memory.
+func runLocalJob(ctx context.Context, job releaseJob, level int) {
+	localJobsMu.Lock()
+	defer localJobsMu.Unlock()
+
+	key := localJobKey{job.Name, level}
+	run, ok := localJobs[key]
+	if !ok {
+		run = &releaseJobRun{Job: job.Name, LevelID: level, MaxAttempts: job.Attempts, Local: true}
+		localJobs[key] = run
+	}
+	if run.FinishedAt != nil || run.Attempts >= job.Attempts || time.Now().Before(run.NextAttemptAt) {
+		return
+	}
+
+	run.Attempts++
+	if err := job.Run(ctx, level); err != nil {
+		log.Printf("%s for level %d failed, attempt %d of %d, %v", job.Name, level, run.Attempts, job.Attempts, err)
+		run.LastError = err.Error()
+		run.NextAttemptAt = time.Now().Add(jobRetryDelay * time.Duration(run.Attempts))
+		return
+	}
+	log.Printf("%s for level %d done", job.Name, level)
+	now := time.Now()
+	run.LastError = ""
+	run.FinishedAt = &now
+}
+
+// jobLockKey turns a job name into the first key of its advisory locks, the
+// level being the second.
+func jobLockKey(name string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte("atlus release job " + name))
+	return int32(h.Sum32())
+}
+
+// warmPuzzles loads the released level into the puzzle catalog right away
+// instead of at the next poll, which also picks up sealed bundles.
+func warmPuzzles(ctx context.Context, level int) error {
+	if err := puzzles.reload(false); err != nil {
+		return err
+	}
+	if len(puzzles.levelParts(level)) == 0 {
+		return fmt.Errorf("no puzzle loaded for level %d", level)
+	}
+	return nil
+}
+
+// snapshotLeaderboard records the overall standings as the level is
+// released.
+func snapshotLeaderboard(ctx context.Context, level int) error {
+	_, err := globals.DB.Exec(ctx, `
+	    INSERT INTO leaderboard_snapshots (level_id, github_id, points, rank)
+	    SELECT $2, u.github_id, COALESCE(SUM(p.points), 0),
+	        RANK() OVER (ORDER BY COALESCE(SUM(p.points), 0) DESC)
+	    FROM users u
+	    LEFT JOIN (`+liveTables.Replace(levelPointsQuery(1))+`) p ON p.github_id = u.github_id
+	    GROUP BY u.github_id
+	    ON CONFLICT DO NOTHING
+	    `, levelPoints, level)
+	return err
+}
+
+// notifyRelease posts the release to NOTIFY_WEBHOOK_URL. The message is
+// sent as both content and text, which Discord and Slack webhooks accept.
+func notifyRelease(ctx context.Context, level int) error {
+	if globals.NotifyWebhook == "" {
+		return nil
+	}
+
+	var name string
+	err := globals.DB.QueryRow(ctx, `SELECT name FROM levels WHERE level_id = $1`, level).Scan(&name)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Level %d, %s, is out! http://%s:%s/puzzles/level%d", level, name, globals.Hostname, globals.Port, level)
+	body, err := json.Marshal(map[string]string{"content": msg, "text": msg})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, globals.NotifyWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
+
+// fetchReleaseJobs lists job runs for the admin page, latest levels first.
+// Local jobs are the runs of the instance serving the page.
+func fetchReleaseJobs(ctx context.Context) ([]releaseJobRun, error) {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT job, level_id, attempts, last_error, next_attempt_at, finished_at
+	    FROM release_jobs
+	    ORDER BY level_id DESC, job
+	    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []releaseJobRun
+	for rows.Next() {
+		var j releaseJobRun
+		if err := rows.Scan(&j.Job, &j.LevelID, &j.Attempts, &j.LastError, &j.NextAttemptAt, &j.FinishedAt); err != nil {
+			return nil, err
+		}
+		j.MaxAttempts = jobAttempts(j.Job)
+		runs = append(runs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	localJobsMu.Lock()
+	for _, run := range localJobs {
+		runs = append(runs, *run)
+	}
+	localJobsMu.Unlock()
+
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].LevelID != runs[j].LevelID {
+			return runs[i].LevelID > runs[j].LevelID
+		}
+		return runs[i].Job < runs[j].Job
+	})
+	return runs, nil
+}
+
+// RetryJobHandler gives an unfinished job its attempts back so the
+// scheduler picks it up again.
+func RetryJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+
+	job := r.FormValue("job")
+	level, err := strconv.Atoi(r.FormValue("level"))
+	if err != nil {
+		http.Error(w, "Invalid url request", http.StatusBadRequest)
+		return
+	}
+
+	_, err = globals.DB.Exec(ctx, `
+	    UPDATE release_jobs SET attempts = 0, next_attempt_at = NOW()
+	    WHERE job = $1 AND level_id = $2 AND finished_at IS NULL
+	    `, job, level)
+	if err != nil {
+		log.Printf("error retrying the job, %v", err)
+		http.Error(w, "Unable to retry the job", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s retried %s for level %d", sdata.Username, job, level)
+	http.Redirect(w, r, "/admin#jobs", http.StatusSeeOther)
+}
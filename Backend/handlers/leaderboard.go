@@ -2,12 +2,22 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
+	"maps"
+	"math"
 	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	pgx "github.com/jackc/pgx/v5"
 	"github.com/sceptix-club/atlus/Backend/globals"
 )
 
@@ -15,10 +25,57 @@ const (
 	StreakRune   = "streak"
 	FlashRune    = "flash"
 	ChampionRune = "champion"
+	ScoreRune    = "score"
 	UserStats    = "stats"
 )
 
-type leaderboardFunc func(ctx context.Context) (map[string]any, error)
+// number of solvers shown per page on a level leaderboard
+const levelLeaderboardPageSize = 25
+
+const (
+	// default and maximum number of rows in a single rune page
+	leaderboardPageSize    = 10
+	leaderboardMaxPageSize = 50
+)
+
+// how often each rune fragment re-polls itself
+var runePoll = map[string]string{
+	StreakRune:   "3s",
+	FlashRune:    "5s",
+	ChampionRune: "3s",
+	ScoreRune:    "3s",
+	UserStats:    "3s",
+	TeamRune:     "5s",
+}
+
+// points for the fastest solver of a level, every later rank gets one less
+const levelPoints = 100
+
+// leaderboardQuery holds the paging, search and viewer parameters shared by
+// every rune.
+type leaderboardQuery struct {
+	After    string // sort key cursor, only rows after it are returned
+	Before   string // sort key cursor, only rows before it, takes precedence
+	Limit    int    // rows per page
+	Search   string // case-insensitive username filter
+	User     int64  // github_id whose stats the stats rune shows
+	ViewerID int64  // 0 for anonymous viewers
+	Board    int    // private board to restrict to, 0 for everyone
+	Frozen   bool   // read from the freeze snapshot instead of live tables
+}
+
+// leaderboardPage is a single page of a rune, rendered either as an html
+// fragment or as json.
+type leaderboardPage struct {
+	Rune   string `json:"rune"`
+	Rows   any    `json:"rows"`
+	Mine   any    `json:"mine,omitempty"`
+	Prev   string `json:"prev_cursor,omitempty"`
+	Next   string `json:"next_cursor,omitempty"`
+	Frozen bool   `json:"frozen"`
+}
+
+type leaderboardFunc func(ctx context.Context, q leaderboardQuery) (leaderboardPage, error)
 
 func LeaderboardHandler(tpl *template.Template) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +84,8 @@ func LeaderboardHandler(tpl *template.Template) http.HandlerFunc {
 		tpl.ExecuteTemplate(w, "leaderboard", map[string]any{
 			"LoggedIn":    true,
 			"Leaderboard": true,
+			"Q":           strings.TrimSpace(r.URL.Query().Get("q")),
+			"TeamMode":    globals.TeamMode,
 		})
 	}
 }
@@ -34,10 +93,6 @@ func LeaderboardHandler(tpl *template.Template) http.HandlerFunc {
 func LeaderboardLiveHandler(tpl *template.Template) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		slug := r.PathValue("slug")
-		username := r.URL.Query().Get("user")
-
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, "user", username)
 
 		handler, err := runeHandler(slug)
 		if err != nil {
@@ -45,8 +100,45 @@ func LeaderboardLiveHandler(tpl *template.Template) http.HandlerFunc {
 			return
 		}
 
-		data, err := handler(ctx)
-		err = tpl.ExecuteTemplate(w, "leaderboard-table", data)
+		q := parseLeaderboardQuery(r)
+		if !canViewBoard(r.Context(), q) {
+			http.Error(w, "Unknown leaderboard", http.StatusNotFound)
+			return
+		}
+
+		page, err := handler(r.Context(), q)
+		if err != nil {
+			http.Error(w, "Unable to fetch the leaderboard", http.StatusInternalServerError)
+			return
+		}
+
+		// the fragment re-renders its own polling wrapper, so the current
+		// url keeps the cursor and search across refreshes
+		self := *r.URL
+		first := *r.URL
+		params := first.Query()
+		params.Del("after")
+		params.Del("before")
+		first.RawQuery = params.Encode()
+		prev := first
+		prev.RawQuery = withParam(params, "before", page.Prev)
+		next := first
+		next.RawQuery = withParam(params, "after", page.Next)
+
+		err = tpl.ExecuteTemplate(w, "leaderboard-table", map[string]any{
+			"Rune":     page.Rune,
+			"Rows":     page.Rows,
+			"Mine":     page.Mine,
+			"Viewer":   q.ViewerID,
+			"Poll":     runePoll[page.Rune],
+			"Self":     self.RequestURI(),
+			"FirstURL": first.RequestURI(),
+			"PrevURL":  prev.RequestURI(),
+			"NextURL":  next.RequestURI(),
+			"HasPrev":  page.Prev != "",
+			"HasNext":  page.Next != "",
+			"Frozen":   page.Frozen,
+		})
 		if err != nil {
 			log.Printf("error executing the template, %v", err)
 			return
@@ -54,97 +146,329 @@ func LeaderboardLiveHandler(tpl *template.Template) http.HandlerFunc {
 	}
 }
 
-func runeHandler(slug string) (leaderboardFunc, error) {
-	switch slug {
-	case StreakRune:
-		return streakHandler, nil
-	case FlashRune:
-		return flashHandler, nil
-	case ChampionRune:
-		return championHandler, nil
-	case UserStats:
-		return userStatsHandler, nil
+// LeaderboardAPIHandler serves the same rune pages as json.
+func LeaderboardAPIHandler(w http.ResponseWriter, r *http.Request) {
+	handler, err := runeHandler(r.PathValue("slug"))
+	if err != nil {
+		http.Error(w, "Unknown leaderboard", http.StatusNotFound)
+		return
+	}
+
+	q := parseLeaderboardQuery(r)
+	if !canViewBoard(r.Context(), q) {
+		http.Error(w, "Unknown leaderboard", http.StatusNotFound)
+		return
+	}
+
+	page, err := handler(r.Context(), q)
+	if err != nil {
+		http.Error(w, "Unable to fetch the leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("error encoding the leaderboard, %v", err)
 	}
-	return nil, nil
 }
 
-func streakHandler(ctx context.Context) (map[string]any, error) {
+// withParam encodes params with key set to value, leaving params alone.
+func withParam(params url.Values, key, value string) string {
+	params = maps.Clone(params)
+	params.Set(key, value)
+	return params.Encode()
+}
 
-	res := map[string]any{}
+func parseLeaderboardQuery(r *http.Request) leaderboardQuery {
+	params := r.URL.Query()
+	q := leaderboardQuery{
+		Limit:  leaderboardPageSize,
+		Search: strings.TrimSpace(params.Get("q")),
+	}
+	if user, err := strconv.ParseInt(params.Get("user"), 10, 64); err == nil && user > 0 {
+		q.User = user
+	}
+	q.After = decodeCursor(params.Get("after"))
+	q.Before = decodeCursor(params.Get("before"))
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil && limit > 0 {
+		q.Limit = min(limit, leaderboardMaxPageSize)
+	}
+	if board, err := strconv.Atoi(params.Get("board")); err == nil && board > 0 {
+		q.Board = board
+	}
+	if sdata, ok := optionalSession(r); ok {
+		q.ViewerID = sdata.GithubID
+	}
+	q.Frozen = leaderboardFrozen(r.Context())
+	return q
+}
 
-	type Record struct {
-		Username  string
-		Streak    int
-		GithubUrl string
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// sortKeyPattern matches a sort key as Postgres prints a NUMERIC[], the only
+// thing a cursor may hold.
+var sortKeyPattern = regexp.MustCompile(`^\{-?\d+(\.\d+)?(,-?\d+(\.\d+)?)*\}$`)
+
+// encodeCursor turns the sort key of the row a page starts after or ends
+// before into a cursor, opaque to clients.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeCursor returns the sort key in cursor, empty when it isn't one.
+func decodeCursor(cursor string) string {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !sortKeyPattern.Match(key) {
+		return ""
 	}
+	return string(key)
+}
 
-	var data []Record
+// rankedPage returns one page of a ranked query along with the viewer's own
+// row, and the cursors of the pages before and after it. ranked must expose
+// github_id, username and sort_key, a NUMERIC[] that orders the rows
+// ascending and is unique, e.g. ARRAY[-score, github_id]; cols picks what is
+// scanned, starting with sort_key::TEXT. Pages are keyed on sort_key rather
+// than positions, so rows moving between requests don't shift the pages.
+// Ranks are computed inside ranked, before search and paging, so they stay
+// global. args are bound to $1..$n inside ranked.
+func rankedPage[T any](ctx context.Context, ranked string, cols string, q leaderboardQuery,
+	scan func(row rowScanner) (T, string, error), args ...any) (data []T, mine *T, prev, next string, err error) {
+
+	ranked = leaderboardTables(ranked, q.Frozen)
+	n := len(args)
+	pattern := "%" + likeEscaper.Replace(q.Search) + "%"
+
+	// fetch reads up to a row past the page, from cursor on in the direction
+	// of cmp, "<" walking backwards
+	fetch := func(cmp string, cursor string) ([]T, []string, error) {
+		order := "ASC"
+		if cmp == "<" {
+			order = "DESC"
+		}
+		var bound *string
+		if cursor != "" {
+			bound = &cursor
+		}
 
-	rows, err := globals.DB.Query(ctx, `
-            SELECT username, streak, github_url FROM users
-            ORDER BY streak DESC
-            LIMIT 10
-	    `)
-	if err != nil {
-		log.Printf("error fetching the streak leaderboard, %v", err)
-		return res, err
+		rows, err := globals.DB.Query(ctx, fmt.Sprintf(`
+		    WITH ranked AS (%s)
+		    SELECT %s FROM ranked
+		    WHERE ($%d::TEXT IS NULL OR sort_key %s $%d::TEXT::NUMERIC[]) AND username ILIKE $%d
+		    ORDER BY sort_key %s
+		    LIMIT $%d
+		    `, ranked, cols, n+1, cmp, n+1, n+2, order, n+3), append(args, bound, pattern, q.Limit+1)...)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+
+		var data []T
+		var keys []string
+		for rows.Next() {
+			rec, key, err := scan(rows)
+			if err != nil {
+				return nil, nil, err
+			}
+			data = append(data, rec)
+			keys = append(keys, key)
+		}
+		return data, keys, rows.Err()
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var row Record
-		err := rows.Scan(&row.Username, &row.Streak, &row.GithubUrl)
+	var keys []string
+	if q.Before != "" {
+		data, keys, err = fetch("<", q.Before)
 		if err != nil {
-			log.Printf("error scanning the row for streaks, %v", err)
-			return res, err
+			return nil, nil, "", "", err
+		}
+		if len(data) > q.Limit {
+			data, keys = data[:q.Limit], keys[:q.Limit]
+			slices.Reverse(data)
+			slices.Reverse(keys)
+			prev, next = encodeCursor(keys[0]), encodeCursor(keys[len(keys)-1])
+		} else {
+			// reached the top, which is served as the first page so it's full
+			data = nil
+		}
+	}
+	if data == nil {
+		data, keys, err = fetch(">", q.After)
+		if err != nil {
+			return nil, nil, "", "", err
+		}
+		if len(data) > q.Limit {
+			data, keys = data[:q.Limit], keys[:q.Limit]
+			next = encodeCursor(keys[q.Limit-1])
+		}
+		if q.After != "" && len(data) > 0 {
+			prev = encodeCursor(keys[0])
 		}
-		data = append(data, row)
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("iteration error: %v", err)
-		return nil, err
+	if q.ViewerID == 0 {
+		return data, nil, prev, next, nil
 	}
 
-	res = map[string]any{
-		"StreakRune": data,
+	own, key, err := scan(globals.DB.QueryRow(ctx, fmt.Sprintf(`
+	    WITH ranked AS (%s)
+	    SELECT %s FROM ranked
+	    WHERE github_id = $%d
+	    LIMIT 1
+	    `, ranked, cols, n+1), append(args, q.ViewerID)...))
+	if err == pgx.ErrNoRows {
+		return data, nil, prev, next, nil
 	}
-	return res, nil
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	if slices.Contains(keys, key) {
+		// already visible, no need to pin it
+		return data, nil, prev, next, nil
+	}
+	return data, &own, prev, next, nil
 }
 
-func flashHandler(ctx context.Context) (map[string]any, error) {
-	res := map[string]any{}
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
 
-	type Record struct {
-		LevelId   string
-		Username  string
-		TimeTaken time.Duration
+// boardFilter restricts col to the members of the private board bound at
+// $param, or lets everyone through when that board is 0.
+func boardFilter(col string, param int) string {
+	return fmt.Sprintf(`($%d = 0 OR %s IN (
+	        SELECT github_id FROM private_board_members WHERE board_id = $%d))`, param, col, param)
+}
+
+// LevelLeaderboardHandler lists every solver of a single level ranked by
+// time_taken, paginated, with the viewer's own row pinned when it falls
+// outside the page being shown.
+func LevelLeaderboardHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		slug := r.PathValue("slug")
+		level, err := getLevelParam(slug)
+		if err != nil {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		if sdata.NextReleaseLevel <= level {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"NotReleased": true,
+				"NextLevel":   sdata.NextReleaseLevel,
+			})
+			return
+		}
+
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		frozen := leaderboardFrozen(ctx)
+		board, err := levelLeaderboard(ctx, level, page, sdata.GithubID, frozen)
+		if err != nil {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		data := map[string]any{
+			"LoggedIn":         true,
+			"LevelLeaderboard": true,
+			"Slug":             fmt.Sprintf("level%d", level),
+			"LevelId":          level,
+			"GithubID":         sdata.GithubID,
+			"Frozen":           frozen,
+		}
+		for k, v := range board {
+			data[k] = v
+		}
+
+		err = tpl.ExecuteTemplate(w, "level-leaderboard", data)
+		if err != nil {
+			log.Printf("error executing the template, %v", err)
+		}
 	}
+}
 
-	var data []Record
+type levelRankRecord struct {
+	GithubID   int64
+	Username   string
+	GithubUrl  string
+	TimeTaken  time.Duration
+	Attempts   int
+	Rank       int
+	Percentile int
+}
 
-	rows, err := globals.DB.Query(ctx, `
-	    SELECT DISTINCT ON (level_id) level_id, username, time_taken
-	    FROM submissions
-	    WHERE passed = TRUE
-	    ORDER BY level_id, time_taken ASC
-	    LIMIT 10
-	    `)
+func levelLeaderboard(ctx context.Context, level int, page int, githubID int64, frozen bool) (map[string]any, error) {
+	res := map[string]any{}
 
+	var total int
+	err := globals.DB.QueryRow(ctx, leaderboardTables(`
+	    SELECT COUNT(*) FROM {submissions}
+	    WHERE level_id = $1 AND passed = TRUE
+	    `, frozen), level).Scan(&total)
 	if err != nil {
-		log.Printf("error fetching the Flash leaderboard, %v", err)
+		log.Printf("error counting solvers of level %d, %v", level, err)
+		return res, err
+	}
+
+	pages := max(1, (total+levelLeaderboardPageSize-1)/levelLeaderboardPageSize)
+	page = min(page, pages)
+
+	// ranks and percentiles are computed over every solver before paging,
+	// so the pinned row and the page agree on a user's position
+	rankedQuery := leaderboardTables(`
+	    WITH ranked AS (
+	        SELECT s.github_id, s.username, COALESCE(u.github_url, ''), `+rankedTime("s")+`, s.attempts,
+	            RANK() OVER (ORDER BY `+rankedTime("s")+`) AS rank,
+	            CUME_DIST() OVER (ORDER BY `+rankedTime("s")+`) AS cume
+	        FROM {submissions} s
+	        JOIN {users} u ON u.github_id = s.github_id
+	        WHERE s.level_id = $1 AND s.passed = TRUE
+	    )
+	    SELECT * FROM ranked
+	    `, frozen)
+
+	scan := func(row rowScanner) (levelRankRecord, error) {
+		var rec levelRankRecord
+		var cume float64
+		err := row.Scan(&rec.GithubID, &rec.Username, &rec.GithubUrl, &rec.TimeTaken, &rec.Attempts, &rec.Rank, &cume)
+		rec.Percentile = int(math.Ceil(cume * 100))
+		return rec, err
+	}
+
+	rows, err := globals.DB.Query(ctx, rankedQuery+`
+	    ORDER BY rank, username
+	    LIMIT $2 OFFSET $3
+	    `, level, levelLeaderboardPageSize, (page-1)*levelLeaderboardPageSize)
+	if err != nil {
+		log.Printf("error fetching the leaderboard for level %d, %v", level, err)
 		return res, err
 	}
 	defer rows.Close()
 
+	var data []levelRankRecord
+	onPage := false
 	for rows.Next() {
-		var row Record
-		err := rows.Scan(&row.LevelId, &row.Username, &row.TimeTaken)
+		rec, err := scan(rows)
 		if err != nil {
-			log.Printf("error scanning the row for flash, %v", err)
+			log.Printf("error scanning the row for level leaderboard, %v", err)
 			return res, err
 		}
-		data = append(data, row)
+		if rec.GithubID == githubID {
+			onPage = true
+		}
+		data = append(data, rec)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -152,96 +476,273 @@ func flashHandler(ctx context.Context) (map[string]any, error) {
 		return nil, err
 	}
 
+	var pinned *levelRankRecord
+	if !onPage {
+		rec, err := scan(globals.DB.QueryRow(ctx, rankedQuery+`
+	    WHERE github_id = $2
+	    `, level, githubID))
+		if err == nil {
+			pinned = &rec
+		} else if err != pgx.ErrNoRows {
+			log.Printf("error fetching own row for level %d, %v", level, err)
+			return res, err
+		}
+	}
+
 	res = map[string]any{
-		"FlashRune": data,
+		"Solvers":  data,
+		"Pinned":   pinned,
+		"Total":    total,
+		"Page":     page,
+		"Pages":    pages,
+		"PrevPage": page - 1,
+		"NextPage": page + 1,
+		"HasPrev":  page > 1,
+		"HasNext":  page < pages,
 	}
 	return res, nil
 }
 
-func championHandler(ctx context.Context) (map[string]any, error) {
-	res := map[string]any{}
+func runeHandler(slug string) (leaderboardFunc, error) {
+	switch slug {
+	case StreakRune:
+		return streakHandler, nil
+	case FlashRune:
+		return flashHandler, nil
+	case ChampionRune:
+		return championHandler, nil
+	case ScoreRune:
+		return scoreHandler, nil
+	case UserStats:
+		return userStatsHandler, nil
+	case TeamRune:
+		if globals.TeamMode {
+			return teamHandler, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown rune %q", slug)
+}
 
+func streakHandler(ctx context.Context, q leaderboardQuery) (leaderboardPage, error) {
 	type Record struct {
-		Username     string
-		CurrentLevel int
-		GithubUrl    string
+		Rank      int    `json:"rank"`
+		GithubID  int64  `json:"github_id"`
+		Username  string `json:"username"`
+		Streak    int    `json:"streak"`
+		GithubUrl string `json:"github_url"`
 	}
 
-	var data []Record
+	data, mine, prev, next, err := rankedPage(ctx, `
+	    SELECT github_id, username, COALESCE(github_url, '') AS github_url, streak,
+	        RANK() OVER (ORDER BY streak DESC) AS rank,
+	        ARRAY[-streak, github_id]::NUMERIC[] AS sort_key
+	    FROM {users}
+	    WHERE `+boardFilter("github_id", 1),
+		"sort_key::TEXT, rank, github_id, username, streak, github_url", q,
+		func(row rowScanner) (Record, string, error) {
+			var rec Record
+			var key string
+			err := row.Scan(&key, &rec.Rank, &rec.GithubID, &rec.Username, &rec.Streak, &rec.GithubUrl)
+			return rec, key, err
+		}, q.Board)
+	if err != nil {
+		log.Printf("error fetching the streak leaderboard, %v", err)
+		return leaderboardPage{}, err
+	}
+
+	page := leaderboardPage{Rune: StreakRune, Rows: data, Prev: prev, Next: next, Frozen: q.Frozen}
+	if mine != nil {
+		page.Mine = mine
+	}
+	return page, nil
+}
 
-	rows, err := globals.DB.Query(ctx, `
-	    SELECT username, current_level, github_url FROM users
-	    ORDER BY current_level DESC
-	    LIMIT 10
-	    `)
+func flashHandler(ctx context.Context, q leaderboardQuery) (leaderboardPage, error) {
+	type Record struct {
+		Rank      int           `json:"rank"`
+		LevelId   string        `json:"level_id"`
+		GithubID  int64         `json:"github_id"`
+		Username  string        `json:"username"`
+		TimeTaken time.Duration `json:"time_taken"`
+	}
 
+	// rows are levels rather than users, so there is no single row to pin
+	q.ViewerID = 0
+
+	data, _, prev, next, err := rankedPage(ctx, `
+	    SELECT *, ROW_NUMBER() OVER (ORDER BY level_id) AS rank, ARRAY[level_id]::NUMERIC[] AS sort_key
+	    FROM (
+	        SELECT DISTINCT ON (s.level_id) s.level_id, s.github_id, s.username, `+rankedTime("s")+` AS time_taken
+	        FROM {submissions} s
+	        WHERE s.passed = TRUE
+	        AND `+boardFilter("s.github_id", 1)+`
+	        ORDER BY s.level_id, time_taken ASC
+	    ) fastest
+	    `, "sort_key::TEXT, rank, level_id, github_id, username, time_taken", q,
+		func(row rowScanner) (Record, string, error) {
+			var rec Record
+			var key string
+			err := row.Scan(&key, &rec.Rank, &rec.LevelId, &rec.GithubID, &rec.Username, &rec.TimeTaken)
+			return rec, key, err
+		}, q.Board)
 	if err != nil {
-		log.Printf("error fetching the Champion leaderboard, %v", err)
-		return res, err
+		log.Printf("error fetching the Flash leaderboard, %v", err)
+		return leaderboardPage{}, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var row Record
-		err := rows.Scan(&row.Username, &row.CurrentLevel, &row.GithubUrl)
-		if err != nil {
-			log.Printf("error scanning the row for Champion, %v", err)
-			return res, err
-		}
-		data = append(data, row)
+	return leaderboardPage{Rune: FlashRune, Rows: data, Prev: prev, Next: next, Frozen: q.Frozen}, nil
+}
+
+func championHandler(ctx context.Context, q leaderboardQuery) (leaderboardPage, error) {
+	type Record struct {
+		Rank         int    `json:"rank"`
+		GithubID     int64  `json:"github_id"`
+		Username     string `json:"username"`
+		CurrentLevel int    `json:"current_level"`
+		GithubUrl    string `json:"github_url"`
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("iteration error: %v", err)
-		return nil, err
+	data, mine, prev, next, err := rankedPage(ctx, `
+	    SELECT github_id, username, COALESCE(github_url, '') AS github_url, current_level,
+	        RANK() OVER (ORDER BY current_level DESC) AS rank,
+	        ARRAY[-current_level, github_id]::NUMERIC[] AS sort_key
+	    FROM {users}
+	    WHERE `+boardFilter("github_id", 1),
+		"sort_key::TEXT, rank, github_id, username, current_level, github_url", q,
+		func(row rowScanner) (Record, string, error) {
+			var rec Record
+			var key string
+			err := row.Scan(&key, &rec.Rank, &rec.GithubID, &rec.Username, &rec.CurrentLevel, &rec.GithubUrl)
+			return rec, key, err
+		}, q.Board)
+	if err != nil {
+		log.Printf("error fetching the Champion leaderboard, %v", err)
+		return leaderboardPage{}, err
 	}
 
-	res = map[string]any{
-		"ChampionRune": data,
+	page := leaderboardPage{Rune: ChampionRune, Rows: data, Prev: prev, Next: next, Frozen: q.Frozen}
+	if mine != nil {
+		page.Mine = mine
 	}
-	return res, nil
+	return page, nil
 }
 
-func userStatsHandler(ctx context.Context) (map[string]any, error) {
-	res := map[string]any{}
-	username := ctx.Value("user")
-	if username == "" {
-		return res, fmt.Errorf("error reading user")
-	}
+// rankedTime is the time solvers are ranked on for the submission aliased s,
+// its time_taken plus the penalty of every hint revealed on the level.
+// time_taken itself stays the time from the release to the solve.
+func rankedTime(s string) string {
+	return fmt.Sprintf(`(%[1]s.time_taken + COALESCE((
+	        SELECT SUM(h.penalty) FROM {hint_reveals} h
+	        WHERE h.github_id = %[1]s.github_id AND h.level_id = %[1]s.level_id
+	    ), INTERVAL '0'))`, s)
+}
 
+// levelPointsQuery selects github_id, level_id, time_taken and the points
+// earned for every passed submission, with levelPoints bound at $param.
+// time_taken is the ranked time, hint penalties included, and the cost of
+// every hint revealed on the level is taken off its points.
+func levelPointsQuery(param int) string {
+	return fmt.Sprintf(`
+	    SELECT s.github_id, s.level_id, %[2]s AS time_taken,
+	        GREATEST(0, $%[1]d + 1 - RANK() OVER (PARTITION BY s.level_id ORDER BY %[2]s)
+	            - COALESCE((
+	                SELECT SUM(h.cost) FROM {hint_reveals} h
+	                WHERE h.github_id = s.github_id AND h.level_id = s.level_id
+	            ), 0)) AS points
+	    FROM {submissions} s
+	    WHERE s.passed = TRUE
+	    `, param, rankedTime("s"))
+}
+
+// scoreHandler ranks users by points: each solved level is worth levelPoints
+// for its fastest solver and one point less for every rank after that.
+func scoreHandler(ctx context.Context, q leaderboardQuery) (leaderboardPage, error) {
 	type Record struct {
-		LevelId   string
-		TimeTaken time.Duration
-		Attempts  int
+		Rank      int    `json:"rank"`
+		GithubID  int64  `json:"github_id"`
+		Username  string `json:"username"`
+		Score     int    `json:"score"`
+		GithubUrl string `json:"github_url"`
 	}
 
-	var data []Record
+	data, mine, prev, next, err := rankedPage(ctx, `
+	    SELECT github_id, username, github_url, score,
+	        RANK() OVER (ORDER BY score DESC) AS rank,
+	        ARRAY[-score, github_id]::NUMERIC[] AS sort_key
+	    FROM (
+	        SELECT u.github_id, u.username, COALESCE(u.github_url, '') AS github_url,
+	            COALESCE(SUM(p.points), 0)::INT AS score
+	        FROM {users} u
+	        LEFT JOIN (`+levelPointsQuery(2)+`) p ON p.github_id = u.github_id
+	        WHERE `+boardFilter("u.github_id", 1)+`
+	        GROUP BY u.github_id
+	    ) scores
+	    `, "sort_key::TEXT, rank, github_id, username, score, github_url", q,
+		func(row rowScanner) (Record, string, error) {
+			var rec Record
+			var key string
+			err := row.Scan(&key, &rec.Rank, &rec.GithubID, &rec.Username, &rec.Score, &rec.GithubUrl)
+			return rec, key, err
+		}, q.Board, levelPoints)
+	if err != nil {
+		log.Printf("error fetching the Score leaderboard, %v", err)
+		return leaderboardPage{}, err
+	}
 
-	rows, err := globals.DB.Query(ctx, `
-	    SELECT level_id, time_taken, attempts FROM submissions
-	    WHERE username = $1
-	    AND passed = TRUE
-	    ORDER BY time_taken ASC
-	    `, username)
+	page := leaderboardPage{Rune: ScoreRune, Rows: data, Prev: prev, Next: next, Frozen: q.Frozen}
+	if mine != nil {
+		page.Mine = mine
+	}
+	return page, nil
+}
+
+func userStatsHandler(ctx context.Context, q leaderboardQuery) (leaderboardPage, error) {
+	if q.User == 0 {
+		return leaderboardPage{}, fmt.Errorf("error reading user")
+	}
 
+	// hidden profiles only show their stats to their owner
+	visible, err := profileVisible(ctx, q.User, q.ViewerID)
 	if err != nil {
-		log.Printf("error fetching the Champion leaderboard, %v", err)
-		return res, err
+		log.Printf("error checking profile visibility, %v", err)
+		return leaderboardPage{}, err
+	}
+	if !visible {
+		return leaderboardPage{Rune: UserStats, Frozen: q.Frozen}, nil
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var row Record
-		err := rows.Scan(&row.LevelId, &row.TimeTaken, &row.Attempts)
-		if err != nil {
-			log.Printf("error scanning the row for stats, %v", err)
-			return res, err
-		}
-		data = append(data, row)
+	type Record struct {
+		Rank      int           `json:"rank"`
+		LevelId   string        `json:"level_id"`
+		TimeTaken time.Duration `json:"time_taken"`
+		Attempts  int           `json:"attempts"`
+		Hints     int           `json:"hints"`
 	}
 
-	res = map[string]any{
-		"UserStats": data,
+	// every row belongs to the same user, search and pinning don't apply
+	q.Search = ""
+	q.ViewerID = 0
+
+	data, _, prev, next, err := rankedPage(ctx, `
+	    SELECT github_id, username, level_id, time_taken, attempts,
+	        (SELECT COUNT(*) FROM {hint_reveals} h
+	            WHERE h.github_id = s.github_id AND h.level_id = s.level_id) AS hints,
+	        RANK() OVER (ORDER BY time_taken ASC) AS rank,
+	        ARRAY[EXTRACT(EPOCH FROM time_taken), level_id]::NUMERIC[] AS sort_key
+	    FROM {submissions} s
+	    WHERE github_id = $1
+	    AND passed = TRUE
+	    `, "sort_key::TEXT, rank, level_id, time_taken, attempts, hints", q,
+		func(row rowScanner) (Record, string, error) {
+			var rec Record
+			var key string
+			err := row.Scan(&key, &rec.Rank, &rec.LevelId, &rec.TimeTaken, &rec.Attempts, &rec.Hints)
+			return rec, key, err
+		}, q.User)
+	if err != nil {
+		log.Printf("error fetching the user stats, %v", err)
+		return leaderboardPage{}, err
 	}
-	return res, nil
+
+	return leaderboardPage{Rune: UserStats, Rows: data, Prev: prev, Next: next, Frozen: q.Frozen}, nil
 }
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"{-12,4567}", "{-12,4567}"},
+		{"{3723.123456,7}", "{3723.123456,7}"},
+		{"{5}", "{5}"},
+		{"{}", ""},
+		{"{1,}", ""},
+		{"{1,2} OR 1=1", ""},
+		{"{NULL,1}", ""},
+		{"12", ""},
+	}
+	for _, tt := range tests {
+		if got := decodeCursor(encodeCursor(tt.key)); got != tt.want {
+			t.Errorf("decodeCursor(encodeCursor(%q)) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+
+	if got := decodeCursor("not base64!"); got != "" {
+		t.Errorf("decodeCursor of garbage = %q, want none", got)
+	}
+}
+
+func TestLeaderboardTablesFrozen(t *testing.T) {
+	// a frozen board mustn't read any live table, hint costs included
+	live := regexp.MustCompile(`(?:^|[^_{])\b(users|submissions|hint_reveals)\b`)
+	query := leaderboardTables(levelPointsQuery(1), true)
+	if m := live.FindString(query); m != "" {
+		t.Errorf("frozen points query reads a live table, %q in:\n%s", m, query)
+	}
+}
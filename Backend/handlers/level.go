@@ -1,16 +1,16 @@
 package handlers
 
 import (
-	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/sceptix-club/atlus/Backend/globals"
-	"github.com/yuin/goldmark"
 )
 
 func LevelHandler(tpl *template.Template) http.HandlerFunc {
@@ -45,31 +45,79 @@ func LevelHandler(tpl *template.Template) http.HandlerFunc {
 		}
 
 		newSlug := fmt.Sprintf("level%d", level)
-		filePath := "./puzzles/" + newSlug + "/" + newSlug + ".md"
-		file, err := os.Open(filePath)
-		if err != nil {
+		parts := puzzles.levelParts(level)
+		if len(parts) == 0 {
 			globals.RenderInfoPage(tpl, w, true, map[string]any{
 				"Unexpected": true,
 			})
-			log.Printf("failed to open puzzle file %s: %v", filePath, err)
+			log.Printf("no puzzle loaded for %s", newSlug)
 			return
 		}
-		defer file.Close()
 
-		b, err := io.ReadAll(file)
+		hints, err := levelHints(ctx, sdata.GithubID, level)
 		if err != nil {
-			log.Panic("can't read the file")
+			log.Printf("error fetching hints, %v", err)
+		}
+
+		// the page differs per user through the input preview and hints
+		h := sha1.New()
+		var modTime time.Time
+		for _, part := range parts {
+			fmt.Fprintln(h, part.ETag)
+			if part.ModTime.After(modTime) {
+				modTime = part.ModTime
+			}
 		}
-		var buf bytes.Buffer
-		if err := goldmark.Convert(b, &buf); err != nil {
-			log.Panic("Cannot read markdown")
+		fmt.Fprintf(h, "%d %d %v", sdata.GithubID, sdata.InputID, hints)
+		etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+
+		w.Header().Set("ETag", etag)
+		// embedded puzzles have no modification time
+		if !modTime.IsZero() {
+			w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if notModified(r, etag, modTime) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var puzzle template.HTML
+		var input string
+		for i, part := range parts {
+			if part.InputPreview && input == "" {
+				problemSet, err := readProblemSet(level, sdata.InputID)
+				if err != nil {
+					log.Printf("error reading the input for the preview, %v", err)
+				}
+				input = problemSet.Input
+			}
+			if i > 0 {
+				puzzle += "\n<hr>\n"
+			}
+			if part.InputPreview {
+				puzzle += withInputPreview(part.HTML, input)
+			} else {
+				puzzle += part.HTML
+			}
 		}
 
 		tpl.ExecuteTemplate(w, "level", map[string]any{
 			"Level":    true,
 			"LoggedIn": true,
 			"Slug":     newSlug,
-			"Puzzle":   template.HTML(buf.String()),
+			"Puzzle":   puzzle,
+			"Hints":    hints,
 		})
 	}
 }
+
+// notModified checks the conditional headers of a GET, If-None-Match taking
+// precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return strings.Contains(match, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modTime.IsZero() && !modTime.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// lines shown by {{input-preview}} when no count is given
+const inputPreviewLines = 10
+
+var (
+	// puzzleMarkdown renders trusted files. Raw html is let through so the
+	// directives can expand to it, the sanitizer still runs afterwards.
+	puzzleMarkdown = newMarkdown(gmhtml.WithUnsafe())
+	userMarkdown   = newMarkdown()
+
+	markdownPolicy = newMarkdownPolicy()
+
+	inputPreviewMarker = regexp.MustCompile(`\{\{input-preview:(\d+)\}\}`)
+)
+
+func newMarkdown(opts ...renderer.Option) goldmark.Markdown {
+	return goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Footnote,
+			highlighting.NewHighlighting(
+				highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+			),
+			mathExtension{},
+		),
+		goldmark.WithRendererOptions(opts...),
+	)
+}
+
+// newMarkdownPolicy is bluemonday's user content policy plus the classes
+// and elements the extensions and directives render.
+func newMarkdownPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowElements("details", "summary")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[\w\- ]+$`)).
+		OnElements("span", "div", "pre", "code", "details", "a", "sup", "li", "ol")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}
+
+// renderMarkdown renders trusted markdown such as puzzles and hints,
+// expanding the directives first. {{input-preview}} is left as a marker for
+// withInputPreview since it differs between users.
+func renderMarkdown(src []byte) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := puzzleMarkdown.Convert(expandDirectives(src), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// renderUserMarkdown renders markdown written by users, without raw html or
+// directives.
+func renderUserMarkdown(src []byte) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := userMarkdown.Convert(src, &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// withInputPreview fills the {{input-preview}} markers of a rendered puzzle
+// with the first lines of the user's own input.
+func withInputPreview(puzzle template.HTML, input string) template.HTML {
+	lines := strings.Split(strings.TrimRight(input, "\n"), "\n")
+	return template.HTML(inputPreviewMarker.ReplaceAllStringFunc(string(puzzle), func(marker string) string {
+		n, _ := strconv.Atoi(inputPreviewMarker.FindStringSubmatch(marker)[1])
+		preview := lines[:min(n, len(lines))]
+		if n < len(lines) {
+			preview = append(preview[:len(preview):len(preview)], "...")
+		}
+		return html.EscapeString(strings.Join(preview, "\n"))
+	}))
+}
+
+// expandDirectives turns the puzzle directives into html before the
+// markdown is parsed. Each directive sits on a line of its own:
+//
+//	{{input-preview}} or {{input-preview 5}}
+//	{{spoiler}} or {{spoiler Title}} ... {{/spoiler}}
+//	$$ ... $$ for display math
+//
+// Fenced code blocks are left alone.
+func expandDirectives(src []byte) []byte {
+	var out bytes.Buffer
+	var fence string
+	var math []string
+	inMath := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	scanner.Buffer(nil, len(src)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			out.WriteString(line + "\n")
+
+		case inMath:
+			if trimmed == "$$" {
+				inMath = false
+				fmt.Fprintf(&out, "\n<div class=\"math display\">\\[%s\\]</div>\n\n", html.EscapeString(strings.Join(math, "\n")))
+				math = nil
+			} else {
+				math = append(math, line)
+			}
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:3]
+			out.WriteString(line + "\n")
+
+		case trimmed == "$$":
+			inMath = true
+
+		case strings.HasPrefix(trimmed, "{{input-preview") && strings.HasSuffix(trimmed, "}}"):
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(trimmed, "{{input-preview"), "}}")))
+			if err != nil || n < 1 {
+				n = inputPreviewLines
+			}
+			fmt.Fprintf(&out, "\n<pre class=\"input-preview\">{{input-preview:%d}}</pre>\n\n", n)
+
+		case strings.HasPrefix(trimmed, "{{spoiler") && strings.HasSuffix(trimmed, "}}"):
+			title := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(trimmed, "{{spoiler"), "}}"))
+			if title == "" {
+				title = "Spoiler"
+			}
+			fmt.Fprintf(&out, "\n<details class=\"spoiler\"><summary>%s</summary>\n\n", html.EscapeString(title))
+
+		case trimmed == "{{/spoiler}}":
+			out.WriteString("\n</details>\n\n")
+
+		default:
+			out.WriteString(line + "\n")
+		}
+	}
+
+	// an unclosed math block is kept as written
+	if inMath {
+		out.WriteString("$$\n" + strings.Join(math, "\n") + "\n")
+	}
+	return out.Bytes()
+}
+
+var kindMath = ast.NewNodeKind("Math")
+
+// mathNode is $inline$ or $$display$$ math, rendered with the \( \) and
+// \[ \] delimiters KaTeX's auto-render picks up.
+type mathNode struct {
+	ast.BaseInline
+	Display bool
+}
+
+func (n *mathNode) Kind() ast.NodeKind { return kindMath }
+
+func (n *mathNode) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+type mathParser struct{}
+
+func (mathParser) Trigger() []byte { return []byte{'$'} }
+
+// Parse follows pandoc: the opening $ can't be followed by a space, the
+// closing one can't follow a space or be followed by a digit, so prices
+// like $5 and $10 stay text.
+func (mathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, seg := block.PeekLine()
+	delim := 1
+	if len(line) > 1 && line[1] == '$' {
+		delim = 2
+	}
+
+	body := line[delim:]
+	if len(body) == 0 || body[0] == ' ' {
+		return nil
+	}
+	end := bytes.Index(body, line[:delim])
+	if end <= 0 || body[end-1] == ' ' {
+		return nil
+	}
+	if after := end + delim; delim == 1 && after < len(body) && body[after] >= '0' && body[after] <= '9' {
+		return nil
+	}
+
+	node := &mathNode{Display: delim == 2}
+	node.AppendChild(node, ast.NewRawTextSegment(text.NewSegment(seg.Start+delim, seg.Start+delim+end)))
+	block.Advance(delim + end + delim)
+	return node
+}
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, renderMath)
+}
+
+func renderMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	open, close, class := `\(`, `\)`, "math inline"
+	if node.(*mathNode).Display {
+		open, close, class = `\[`, `\]`, "math display"
+	}
+
+	fmt.Fprintf(w, `<span class="%s">%s`, class, open)
+	for c := node.FirstChild(); c != nil; c = c.NextSibling() {
+		w.Write(util.EscapeHTML(c.(*ast.Text).Segment.Value(source)))
+	}
+	fmt.Fprintf(w, `%s</span>`, close)
+	return ast.WalkSkipChildren, nil
+}
+
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(mathParser{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 500)))
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want template.HTML
+	}{
+		{"prices",
+			"It costs $5, or $10 for two.",
+			"<p>It costs $5, or $10 for two.</p>\n"},
+		{"price after math",
+			"Pay $x$ times $3 each.",
+			`<p>Pay <span class="math inline">\(x\)</span> times $3 each.</p>` + "\n"},
+		{"inline and display math",
+			"Solve $x^2$ and $$y < 2$$ now.",
+			`<p>Solve <span class="math inline">\(x^2\)</span> and <span class="math display">\[y &lt; 2\]</span> now.</p>` + "\n"},
+		{"spaces inside the dollars",
+			"$ x$ and $x $",
+			"<p>$ x$ and $x $</p>\n"},
+		{"dollars in inline code",
+			"Use `$x$` and `$$`.",
+			"<p>Use <code>$x$</code> and <code>$$</code>.</p>\n"},
+		{"dollars in a code fence",
+			"```\n$x$\n$$\n```\n",
+			"<pre><code>$x$\n$$\n</code></pre>\n"},
+		{"dollars in a tilde fence",
+			"~~~\n$$\n{{spoiler}}\n~~~\n",
+			"<pre><code>$$\n{{spoiler}}\n</code></pre>\n"},
+		{"display math block",
+			"$$\na < b\n$$\n",
+			`<div class="math display">\[a &lt; b\]</div>` + "\n"},
+		{"unclosed display math",
+			"$$\nx + y\n",
+			"<p>$$\nx + y</p>\n"},
+		{"spoiler",
+			"{{spoiler Hint <1>}}\nLook closer\n{{/spoiler}}\n",
+			`<details class="spoiler"><summary>Hint &lt;1&gt;</summary>` + "\n<p>Look closer</p>\n</details>\n"},
+		{"nested spoilers",
+			"{{spoiler Outer}}\n{{spoiler}}\ninner\n{{/spoiler}}\n{{/spoiler}}\n",
+			`<details class="spoiler"><summary>Outer</summary>` + "\n" +
+				`<details class="spoiler"><summary>Spoiler</summary>` + "\n<p>inner</p>\n</details>\n</details>\n"},
+		{"input preview",
+			"{{input-preview 3}}\n",
+			`<pre class="input-preview">{{input-preview:3}}</pre>` + "\n"},
+		{"input preview without a count",
+			"{{input-preview}}\n",
+			`<pre class="input-preview">{{input-preview:10}}</pre>` + "\n"},
+		{"raw html is sanitized",
+			"<script>alert(1)</script>\n\nhi",
+			"\n<p>hi</p>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderMarkdown([]byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("renderMarkdown(%q) =\n%q\nwant\n%q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithInputPreview(t *testing.T) {
+	tests := []struct {
+		name   string
+		marker template.HTML
+		input  string
+		want   template.HTML
+	}{
+		{"cut short", "<pre>{{input-preview:1}}</pre>", "a\nb\n", "<pre>a\n...</pre>"},
+		{"whole input", "<pre>{{input-preview:2}}</pre>", "a\nb\n", "<pre>a\nb</pre>"},
+		{"past the end", "<pre>{{input-preview:10}}</pre>", "a\nb\n", "<pre>a\nb</pre>"},
+		{"escaped", "<pre>{{input-preview:1}}</pre>", "<a>", "<pre>&lt;a&gt;</pre>"},
+		{"no marker", "<p>hi</p>", "a", "<p>hi</p>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withInputPreview(tt.marker, tt.input); got != tt.want {
+				t.Errorf("withInputPreview = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
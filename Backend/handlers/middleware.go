@@ -4,6 +4,8 @@ import (
 	"context"
 	"log"
 	"net/http"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
 )
 
 func Authenticator(handler http.HandlerFunc) http.HandlerFunc {
@@ -28,3 +30,32 @@ func Authenticator(handler http.HandlerFunc) http.HandlerFunc {
 		handler.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
+
+// AdminOnly wraps Authenticator and only lets users flagged with is_admin
+// through.
+func AdminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return Authenticator(func(w http.ResponseWriter, r *http.Request) {
+		sdata := r.Context().Value("sessionData").(globals.SessionData)
+		if !sdata.IsAdmin {
+			log.Printf("non admin %s tried to access %s", sdata.Username, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// optionalSession resolves the session cookie when there is one, for public
+// routes that still personalise their output for logged in users.
+func optionalSession(r *http.Request) (globals.SessionData, bool) {
+	c, err := r.Cookie("session")
+	if err != nil {
+		return globals.SessionData{}, false
+	}
+
+	sdata, err := getSessionData(r.Context(), c.Value)
+	if err != nil {
+		return globals.SessionData{}, false
+	}
+	return sdata, true
+}
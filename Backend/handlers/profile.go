@@ -1,32 +1,230 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
 	"time"
 
+	pgx "github.com/jackc/pgx/v5"
 	"github.com/sceptix-club/atlus/Backend/globals"
 )
 
+// userProfile is everything a profile page shows about a user.
+type userProfile struct {
+	GithubID     int64
+	Username     string
+	GithubUrl    string
+	Avatar       string
+	CurrentLevel int
+	Solved       int
+	Streak       int
+	Longest      int
+	FirstTry     int
+	Public       bool
+	CreatedAt    time.Time
+}
+
 func ProfileHandler(tpl *template.Template) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
 		sdata := ctx.Value("sessionData").(globals.SessionData)
 
-		created := time.Now().UTC().Sub(sdata.CreatedAt)
-		joined := fmt.Sprintf("Joined %v ago", created)
-
-		tpl.ExecuteTemplate(w, "base", map[string]any{
-			"LoggedIn":     true,
-			"Profile":      true,
-			"Avatar":       sdata.Avatar,
-			"Username":     sdata.Username,
-			"GithubUrl":    sdata.GithubUrl,
-			"CurrentLevel": sdata.CurrentLevel,
-			"Streak":       sdata.Streak,
-			"Joined":       joined,
-		})
+		profile, err := fetchProfile(ctx, sdata.GithubID)
+		if err != nil {
+			log.Printf("error fetching the profile, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		renderProfile(ctx, tpl, w, true, profile, true)
+	}
+}
+
+// PublicProfileHandler shows /u/{username} to anyone, logged in or not,
+// unless the user has hidden their profile. The username is only used to
+// find the github_id, everything else is looked up by id since usernames
+// change when users rename their GitHub account.
+func PublicProfileHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		sdata, loggedIn := optionalSession(r)
+
+		githubID, found, err := profileID(ctx, r.PathValue("username"))
+		if err != nil {
+			log.Printf("error looking up the profile, %v", err)
+			globals.RenderInfoPage(tpl, w, loggedIn, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+		if !found {
+			globals.RenderInfoPage(tpl, w, loggedIn, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		profile, err := fetchProfile(ctx, githubID)
+		if err != nil {
+			log.Printf("error fetching the profile, %v", err)
+			globals.RenderInfoPage(tpl, w, loggedIn, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		own := loggedIn && sdata.GithubID == githubID
+		if !profile.Public && !own {
+			// hidden profiles look the same as missing ones
+			globals.RenderInfoPage(tpl, w, loggedIn, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		renderProfile(ctx, tpl, w, loggedIn, profile, own)
+	}
+}
+
+// ProfileVisibilityHandler lets a user hide their public profile or show it
+// again.
+func ProfileVisibilityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+
+	public := r.FormValue("public") == "true"
+	_, err := globals.DB.Exec(ctx, `
+	    UPDATE users SET public_profile = $1
+	    WHERE github_id = $2
+	    `, public, sdata.GithubID)
+	if err != nil {
+		log.Printf("error updating profile visibility, %v", err)
+		http.Error(w, "Unable to update your profile", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
+
+// profileID resolves a username to the github_id every other profile lookup
+// uses. GitHub usernames are case-insensitive, so the match is too. Stored
+// names can go stale after a rename on GitHub, so several users may match;
+// an exact match wins, then the user who logged in last, as logging in
+// refreshes the stored name.
+func profileID(ctx context.Context, username string) (int64, bool, error) {
+	var githubID int64
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT u.github_id FROM users u
+	    LEFT JOIN sessions s ON s.github_id = u.github_id
+	    WHERE LOWER(u.username) = LOWER($1)
+	    ORDER BY u.username = $1 DESC, s.expires_at DESC NULLS LAST, u.github_id
+	    LIMIT 1
+	    `, username).Scan(&githubID)
+	if err == pgx.ErrNoRows {
+		return 0, false, nil
+	}
+	return githubID, err == nil, err
+}
+
+func fetchProfile(ctx context.Context, githubID int64) (userProfile, error) {
+	p := userProfile{GithubID: githubID}
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT u.username, u.github_url, u.avatar, u.current_level,
+	        u.streak, u.longest_streak, u.first_try_streak, u.public_profile, u.created_at,
+	        (SELECT COUNT(*) FROM submissions s WHERE s.github_id = u.github_id AND s.passed = TRUE)
+	    FROM users u
+	    WHERE u.github_id = $1
+	    `, githubID).Scan(&p.Username, &p.GithubUrl, &p.Avatar, &p.CurrentLevel,
+		&p.Streak, &p.Longest, &p.FirstTry, &p.Public, &p.CreatedAt, &p.Solved)
+	return p, err
+}
+
+// profileVisible reports whether viewer may see githubID's stats, which is
+// the case for public profiles and for users looking at their own.
+func profileVisible(ctx context.Context, githubID, viewer int64) (bool, error) {
+	if githubID == viewer {
+		return true, nil
+	}
+
+	var public bool
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT public_profile FROM users WHERE github_id = $1
+	    `, githubID).Scan(&public)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return public, err
+}
+
+func renderProfile(ctx context.Context, tpl *template.Template, w http.ResponseWriter, loggedIn bool, p userProfile, own bool) {
+	created := time.Now().UTC().Sub(p.CreatedAt)
+	joined := fmt.Sprintf("Joined %v ago", created)
+
+	badges, err := userBadges(ctx, p.GithubID)
+	if err != nil {
+		log.Printf("error fetching badges, %v", err)
+	}
+
+	hasCertificate := false
+	calendarURL := ""
+	if own {
+		hasCertificate, err = userHasCertificate(ctx, p.GithubID)
+		if err != nil {
+			log.Printf("error checking for a certificate, %v", err)
+		}
+		token, err := calendarToken(ctx, p.GithubID)
+		if err != nil {
+			log.Printf("error fetching the calendar token, %v", err)
+		}
+		if token != "" {
+			calendarURL = fmt.Sprintf("http://%s:%s/schedule/%s.ics", globals.Hostname, globals.Port, token)
+		}
+	}
+
+	// the svgs are inlined so their tooltips work, they're also served on
+	// their own under /u/{username} for embedding
+	heatmap, err := activityHeatmap(ctx, p.GithubID)
+	if err != nil {
+		log.Printf("error rendering the heatmap, %v", err)
+	}
+	timeline, err := levelTimeline(ctx, p.GithubID)
+	if err != nil {
+		log.Printf("error rendering the timeline, %v", err)
+	}
+
+	err = tpl.ExecuteTemplate(w, "base", map[string]any{
+		"LoggedIn":       loggedIn,
+		"Profile":        true,
+		"Own":            own,
+		"Public":         p.Public,
+		"GithubID":       p.GithubID,
+		"Avatar":         p.Avatar,
+		"Username":       p.Username,
+		"GithubUrl":      p.GithubUrl,
+		"CurrentLevel":   p.CurrentLevel,
+		"Solved":         p.Solved,
+		"Streak":         p.Streak,
+		"Longest":        p.Longest,
+		"FirstTry":       p.FirstTry,
+		"Joined":         joined,
+		"Badges":         badges,
+		"HasCertificate": hasCertificate,
+		"CalendarURL":    calendarURL,
+		"Heatmap":        template.HTML(heatmap),
+		"Timeline":       template.HTML(timeline),
+	})
+	if err != nil {
+		log.Printf("error executing the template, %v", err)
 	}
 }
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/sceptix-club/atlus/Backend/content"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// how often the puzzle files are checked for changes
+const puzzleWatchInterval = 5 * time.Second
+
+// Part 1 of a level is levelN/levelN.md, further parts are levelN/partP.md
+// and are shown after it in order.
+var (
+	levelDirPattern = regexp.MustCompile(`^level(\d+)$`)
+	partFilePattern = regexp.MustCompile(`^part(\d+)\.md$`)
+)
+
+type puzzleKey struct {
+	Level int
+	Part  int
+}
+
+// puzzlePage is a rendered puzzle file.
+type puzzlePage struct {
+	HTML         template.HTML
+	InputPreview bool // has {{input-preview}} markers to fill per user
+	ETag         string
+	ModTime      time.Time
+}
+
+// puzzleCatalog holds every puzzle rendered, so the level page doesn't touch
+// the disk or goldmark while hundreds of people refresh it at release.
+type puzzleCatalog struct {
+	mu      sync.RWMutex
+	pages   map[puzzleKey]puzzlePage
+	version string // fingerprint of the files the pages were rendered from
+}
+
+var puzzles = &puzzleCatalog{pages: map[puzzleKey]puzzlePage{}}
+
+// levelParts returns a level's parts in order, nil when it has none.
+func (c *puzzleCatalog) levelParts(level int) []puzzlePage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var parts []puzzlePage
+	for part := 1; ; part++ {
+		page, ok := c.pages[puzzleKey{level, part}]
+		if !ok {
+			return parts
+		}
+		parts = append(parts, page)
+	}
+}
+
+// puzzleFiles lists the puzzle files with their keys.
+func puzzleFiles() (map[puzzleKey]string, error) {
+	dirs, err := globals.Puzzles.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[puzzleKey]string{}
+	for _, dir := range dirs {
+		m := levelDirPattern.FindStringSubmatch(dir.Name())
+		if !dir.IsDir() || m == nil {
+			continue
+		}
+		level, _ := strconv.Atoi(m[1])
+
+		entries, err := globals.Puzzles.ReadDir(dir.Name())
+		if errors.Is(err, content.ErrLocked) {
+			continue
+		}
+		if err != nil {
+			// e.g. a released level whose key hasn't been provided yet,
+			// the other levels still load
+			log.Printf("error reading %s, %v", dir.Name(), err)
+			continue
+		}
+		for _, e := range entries {
+			if e.Name() == dir.Name()+".md" {
+				files[puzzleKey{level, 1}] = path.Join(dir.Name(), e.Name())
+			} else if m := partFilePattern.FindStringSubmatch(e.Name()); m != nil {
+				if part, _ := strconv.Atoi(m[1]); part > 1 {
+					files[puzzleKey{level, part}] = path.Join(dir.Name(), e.Name())
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// fingerprint identifies the current state of the puzzle files by their
+// paths, sizes and modification times.
+func fingerprint(files map[puzzleKey]string) string {
+	names := make([]string, 0, len(files))
+	for _, name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha1.New()
+	for _, name := range names {
+		info, err := globals.Puzzles.Stat(name)
+		if err != nil {
+			fmt.Fprintf(h, "%s missing\n", name)
+			continue
+		}
+		fmt.Fprintf(h, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reload renders every puzzle file into the catalog. Unless forced it's
+// skipped when nothing changed since the last load. A file that fails to
+// render keeps its previous page.
+func (c *puzzleCatalog) reload(force bool) error {
+	files, err := puzzleFiles()
+	if err != nil {
+		return err
+	}
+
+	version := fingerprint(files)
+	c.mu.RLock()
+	unchanged := version == c.version
+	c.mu.RUnlock()
+	if unchanged && !force {
+		return nil
+	}
+
+	pages := make(map[puzzleKey]puzzlePage, len(files))
+	for key, name := range files {
+		page, err := renderPuzzleFile(name)
+		if err != nil {
+			log.Printf("error rendering puzzle %s, %v", name, err)
+			c.mu.RLock()
+			old, ok := c.pages[key]
+			c.mu.RUnlock()
+			if !ok {
+				continue
+			}
+			page = old
+		}
+		pages[key] = page
+	}
+
+	c.mu.Lock()
+	c.pages = pages
+	c.version = version
+	c.mu.Unlock()
+
+	log.Printf("Loaded %d puzzle files", len(pages))
+	return nil
+}
+
+func renderPuzzleFile(name string) (puzzlePage, error) {
+	b, err := globals.Puzzles.ReadFile(name)
+	if err != nil {
+		return puzzlePage{}, err
+	}
+	info, err := globals.Puzzles.Stat(name)
+	if err != nil {
+		return puzzlePage{}, err
+	}
+
+	html, err := renderMarkdown(b)
+	if err != nil {
+		return puzzlePage{}, err
+	}
+
+	sum := sha1.Sum([]byte(html))
+	return puzzlePage{
+		HTML:         html,
+		InputPreview: inputPreviewMarker.MatchString(string(html)),
+		ETag:         hex.EncodeToString(sum[:]),
+		ModTime:      info.ModTime(),
+	}, nil
+}
+
+// LevelReleaseTime tells sealed puzzle bundles when a level may be
+// decrypted. Levels that aren't scheduled stay sealed. The cached schedule
+// can be a poll behind, a release moved later would still look out, so it
+// only turns levels away and the database has the last word on the rest.
+func LevelReleaseTime(level int) (time.Time, error) {
+	ctx := context.Background()
+	release, ok, err := schedule.releaseTime(ctx, level)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, content.ErrLocked
+	}
+	if release.After(time.Now()) {
+		return release, nil
+	}
+
+	err = globals.DB.QueryRow(ctx, `
+	    SELECT release_time::TIMESTAMPTZ FROM levels WHERE level_id = $1
+	    `, level).Scan(&release)
+	if err == pgx.ErrNoRows {
+		return time.Time{}, content.ErrLocked
+	}
+	return release, err
+}
+
+// LoadPuzzles fills the catalog before the server starts taking requests.
+func LoadPuzzles() error {
+	return puzzles.reload(true)
+}
+
+// WatchPuzzles reloads the catalog when a puzzle file changes or the
+// process receives SIGHUP.
+func WatchPuzzles(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(puzzleWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("Reloading puzzles on SIGHUP")
+			force = true
+		case <-ticker.C:
+		}
+
+		if err := puzzles.reload(force); err != nil {
+			log.Printf("error reloading puzzles, %v", err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// scheduledLevel is a level and when it's released.
+type scheduledLevel struct {
+	LevelID     int
+	Name        string
+	ReleaseTime time.Time
+}
+
+// releaseSchedule holds every level's release time, so requests can tell
+// what's released without asking the database. It's refreshed by the
+// scheduler, see RunScheduler.
+type releaseSchedule struct {
+	mu     sync.RWMutex
+	levels []scheduledLevel // by release time
+	loaded bool
+}
+
+var schedule releaseSchedule
+
+func (s *releaseSchedule) refresh(ctx context.Context) error {
+	// compared in Go against time.Now, so read as an instant the same way
+	// the database compares it against NOW()
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT level_id, name, release_time::TIMESTAMPTZ FROM levels
+	    ORDER BY release_time, level_id
+	    `)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var levels []scheduledLevel
+	for rows.Next() {
+		var l scheduledLevel
+		if err := rows.Scan(&l.LevelID, &l.Name, &l.ReleaseTime); err != nil {
+			return err
+		}
+		levels = append(levels, l)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.set(levels)
+	return nil
+}
+
+// set replaces the schedule with levels, which are by release time.
+func (s *releaseSchedule) set(levels []scheduledLevel) {
+	s.mu.Lock()
+	s.levels = levels
+	s.loaded = true
+	s.mu.Unlock()
+}
+
+func (s *releaseSchedule) load(ctx context.Context) error {
+	s.mu.RLock()
+	loaded := s.loaded
+	s.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	return s.refresh(ctx)
+}
+
+// releaseTime reports when a level is released, ok is false for levels
+// that aren't scheduled.
+func (s *releaseSchedule) releaseTime(ctx context.Context, level int) (release time.Time, ok bool, err error) {
+	if err := s.load(ctx); err != nil {
+		return time.Time{}, false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, l := range s.levels {
+		if l.LevelID == level {
+			return l.ReleaseTime, true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// all returns a copy of the schedule, by release time.
+func (s *releaseSchedule) all(ctx context.Context) ([]scheduledLevel, error) {
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]scheduledLevel(nil), s.levels...), nil
+}
+
+// upcoming returns the first level released after now, loading the
+// schedule if that hasn't happened yet.
+func (s *releaseSchedule) upcoming(ctx context.Context, now time.Time) (scheduledLevel, bool, error) {
+	if err := s.load(ctx); err != nil {
+		return scheduledLevel{}, false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, l := range s.levels {
+		if l.ReleaseTime.After(now) {
+			return l, true, nil
+		}
+	}
+	return scheduledLevel{}, false, nil
+}
+
+// nextReleaseLevel is the level released next, 100 once all of them are out.
+func (s *releaseSchedule) nextReleaseLevel(ctx context.Context) (int, error) {
+	next, ok, err := s.upcoming(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		// TODO: this is means there is no new level scheduled to release => end of the event, need to handle it later
+		return 100, nil
+	}
+	return next.LevelID, nil
+}
+
+// LoadSchedule reads the release schedule before the server starts taking
+// requests.
+func LoadSchedule() error {
+	return schedule.refresh(context.Background())
+}
+
+// scheduleEntry is a level on the schedule page and in /api/schedule.
+// Names stay hidden until the level is out.
+type scheduleEntry struct {
+	Level        int       `json:"level"`
+	Name         string    `json:"name,omitempty"`
+	ReleaseTime  time.Time `json:"release_time"`
+	Released     bool      `json:"released"`
+	SecondsUntil int64     `json:"seconds_until,omitempty"`
+}
+
+type scheduleView struct {
+	Now    time.Time       `json:"now"`
+	Levels []scheduleEntry `json:"levels"`
+	Next   *scheduleEntry  `json:"next,omitempty"`
+}
+
+func buildScheduleView(ctx context.Context) (scheduleView, error) {
+	levels, err := schedule.all(ctx)
+	if err != nil {
+		return scheduleView{}, err
+	}
+
+	now := time.Now()
+	view := scheduleView{Now: now.UTC(), Levels: []scheduleEntry{}}
+	for _, l := range levels {
+		e := scheduleEntry{
+			Level:       l.LevelID,
+			ReleaseTime: l.ReleaseTime.UTC(),
+			Released:    !l.ReleaseTime.After(now),
+		}
+		if e.Released {
+			e.Name = l.Name
+		} else {
+			// rounded up, so scripts sleeping this long don't wake early
+			e.SecondsUntil = int64(math.Ceil(l.ReleaseTime.Sub(now).Seconds()))
+		}
+		view.Levels = append(view.Levels, e)
+	}
+	for i := range view.Levels {
+		if !view.Levels[i].Released {
+			view.Next = &view.Levels[i]
+			break
+		}
+	}
+	return view, nil
+}
+
+// ScheduleHandler lists every level's release time, along with a countdown
+// to the next one.
+func ScheduleHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		_, loggedIn := optionalSession(r)
+
+		view, err := buildScheduleView(ctx)
+		if err != nil {
+			log.Printf("error fetching the schedule, %v", err)
+			globals.RenderInfoPage(tpl, w, loggedIn, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		err = tpl.ExecuteTemplate(w, "schedule", map[string]any{
+			"LoggedIn": loggedIn,
+			"Schedule": true,
+			"Levels":   view.Levels,
+			"Next":     view.Next,
+			"Now":      view.Now,
+		})
+		if err != nil {
+			log.Printf("error executing the template, %v", err)
+		}
+	}
+}
+
+// ScheduleAPIHandler serves the schedule as json, so scripts can sleep
+// until a release instead of polling for the input.
+func ScheduleAPIHandler(w http.ResponseWriter, r *http.Request) {
+	view, err := buildScheduleView(r.Context())
+	if err != nil {
+		log.Printf("error fetching the schedule, %v", err)
+		http.Error(w, "Unable to fetch the schedule", http.StatusInternalServerError)
+		return
+	}
+
+	// seconds_until is relative to when the response was made, so a cached
+	// copy would have scripts wake late
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(view); err != nil {
+		log.Printf("error encoding the schedule, %v", err)
+	}
+}
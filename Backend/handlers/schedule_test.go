@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testSchedule is a loaded schedule of 30 levels a day apart, half of them
+// out, so nothing here asks the database.
+func testSchedule() *releaseSchedule {
+	s := &releaseSchedule{}
+	start := time.Now().Add(-15 * 24 * time.Hour)
+	var levels []scheduledLevel
+	for level := 1; level <= 30; level++ {
+		levels = append(levels, scheduledLevel{
+			LevelID:     level,
+			Name:        fmt.Sprintf("Level %d", level),
+			ReleaseTime: start.Add(time.Duration(level) * 24 * time.Hour),
+		})
+	}
+	s.set(levels)
+	return s
+}
+
+func TestScheduleUpcoming(t *testing.T) {
+	s := testSchedule()
+	ctx := context.Background()
+
+	next, err := s.nextReleaseLevel(ctx)
+	if err != nil || next != 16 {
+		t.Errorf("nextReleaseLevel = %d, %v, want 16", next, err)
+	}
+	if _, ok, _ := s.upcoming(ctx, time.Now().Add(30*24*time.Hour)); ok {
+		t.Error("upcoming found a level after the last release")
+	}
+	if next, _ := (&releaseSchedule{loaded: true}).nextReleaseLevel(ctx); next != 100 {
+		t.Errorf("nextReleaseLevel with nothing scheduled = %d, want 100", next)
+	}
+	if _, ok, _ := s.releaseTime(ctx, 31); ok {
+		t.Error("releaseTime found a level that isn't scheduled")
+	}
+}
+
+func TestScheduleRefresh(t *testing.T) {
+	s := &releaseSchedule{}
+	ctx := context.Background()
+	release := time.Now().Add(50 * time.Millisecond)
+	s.set([]scheduledLevel{
+		{LevelID: 1, ReleaseTime: release},
+		{LevelID: 2, ReleaseTime: release.Add(24 * time.Hour)},
+		{LevelID: 3, ReleaseTime: release.Add(48 * time.Hour)},
+	})
+
+	if next, _ := s.nextReleaseLevel(ctx); next != 1 {
+		t.Fatalf("nextReleaseLevel before the release = %d, want 1", next)
+	}
+	time.Sleep(time.Until(release) + 10*time.Millisecond)
+	if next, _ := s.nextReleaseLevel(ctx); next != 2 {
+		t.Errorf("nextReleaseLevel once level 1 is out = %d, want 2", next)
+	}
+
+	// level 2 moved to now, as the scheduler's refresh would load it
+	s.set([]scheduledLevel{
+		{LevelID: 1, ReleaseTime: release},
+		{LevelID: 2, ReleaseTime: time.Now()},
+		{LevelID: 3, ReleaseTime: release.Add(48 * time.Hour)},
+	})
+	if next, _ := s.nextReleaseLevel(ctx); next != 3 {
+		t.Errorf("nextReleaseLevel after a refresh released level 2 = %d, want 3", next)
+	}
+}
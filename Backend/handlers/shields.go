@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// how long camo and browsers may reuse a shield before revalidating it
+const shieldMaxAge = 300
+
+const (
+	shieldFlat        = "flat"
+	shieldFlatSquare  = "flat-square"
+	shieldForTheBadge = "for-the-badge"
+)
+
+// shield is a shields.io style two part badge.
+type shield struct {
+	Label   string
+	Message string
+	Color   string
+}
+
+// ShieldHandler serves /badge/{username}.svg, a README badge showing a
+// user's levels solved, current streak or score rank, picked with
+// ?type=levels|streak|rank. ?style= takes flat, flat-square or for-the-badge.
+func ShieldHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	username, ok := strings.CutSuffix(r.PathValue("file"), ".svg")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	style := r.URL.Query().Get("style")
+	if style != shieldFlatSquare && style != shieldForTheBadge {
+		style = shieldFlat
+	}
+
+	githubID, found, err := profileID(ctx, username)
+	if err == nil && found {
+		found, err = profileVisible(ctx, githubID, 0)
+	}
+	if err != nil {
+		log.Printf("error looking up the badge user, %v", err)
+		http.Error(w, "Unable to render the badge", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, renderShield(shield{"atlus", "user not found", "#9f9f9f"}, style))
+		return
+	}
+
+	s, err := userShield(ctx, githubID, r.URL.Query().Get("type"))
+	if err != nil {
+		log.Printf("error fetching badge data, %v", err)
+		http.Error(w, "Unable to render the badge", http.StatusInternalServerError)
+		return
+	}
+
+	svg := renderShield(s, style)
+	sum := sha1.Sum([]byte(svg))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", shieldMaxAge))
+	w.Header().Set("ETag", etag)
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	fmt.Fprint(w, svg)
+}
+
+func userShield(ctx context.Context, githubID int64, kind string) (shield, error) {
+	switch kind {
+	case "streak":
+		p, err := fetchProfile(ctx, githubID)
+		if err != nil {
+			return shield{}, err
+		}
+		s := shield{"streak", fmt.Sprintf("%d days", p.Streak), "#fe7d37"}
+		if p.Streak == 1 {
+			s.Message = "1 day"
+		}
+		return s, nil
+
+	case "rank":
+		// ranks come from the snapshot while the leaderboard is frozen
+		var rank int
+		err := globals.DB.QueryRow(ctx, leaderboardTables(`
+		    SELECT rank FROM (
+		        SELECT u.github_id, RANK() OVER (ORDER BY COALESCE(SUM(p.points), 0) DESC) AS rank
+		        FROM {users} u
+		        LEFT JOIN (`+levelPointsQuery(1)+`) p ON p.github_id = u.github_id
+		        GROUP BY u.github_id
+		    ) ranks
+		    WHERE github_id = $2
+		    `, leaderboardFrozen(ctx)), levelPoints, githubID).Scan(&rank)
+		if err == pgx.ErrNoRows {
+			return shield{"rank", "unranked", "#9f9f9f"}, nil
+		}
+		if err != nil {
+			return shield{}, err
+		}
+		return shield{"rank", fmt.Sprintf("#%d", rank), "#ffc548"}, nil
+	}
+
+	p, err := fetchProfile(ctx, githubID)
+	if err != nil {
+		return shield{}, err
+	}
+	return shield{"atlus", fmt.Sprintf("%d levels solved", p.Solved), "#ecc67e"}, nil
+}
+
+// renderShield lays out a badge the way shields.io does. Text widths are
+// estimated from the character count since there's no font to measure with.
+func renderShield(s shield, style string) string {
+	label, message := s.Label, s.Message
+	height, fontSize, charWidth, padding := 20, 11, 7, 10
+	if style == shieldForTheBadge {
+		label, message = strings.ToUpper(label), strings.ToUpper(message)
+		height, fontSize, charWidth, padding = 28, 10, 8, 24
+	}
+
+	labelWidth := len(label)*charWidth + padding
+	messageWidth := len(message)*charWidth + padding
+	width := labelWidth + messageWidth
+	radius := 3
+	if style != shieldFlat {
+		radius = 0
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s: %s">`,
+		width, height, html.EscapeString(label), html.EscapeString(message))
+	fmt.Fprintf(&b, `<title>%s: %s</title>`, html.EscapeString(label), html.EscapeString(message))
+	if style == shieldFlat {
+		b.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	}
+	fmt.Fprintf(&b, `<clipPath id="r"><rect width="%d" height="%d" rx="%d" fill="#fff"/></clipPath>`, width, height, radius)
+	fmt.Fprintf(&b, `<g clip-path="url(#r)"><rect width="%d" height="%d" fill="#555"/><rect x="%d" width="%d" height="%d" fill="%s"/>`,
+		labelWidth, height, labelWidth, messageWidth, height, s.Color)
+	if style == shieldFlat {
+		fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="url(#s)"/>`, width, height)
+	}
+	b.WriteString(`</g>`)
+
+	weight := "normal"
+	if style == shieldForTheBadge {
+		weight = "bold"
+	}
+	fmt.Fprintf(&b, `<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="%d" font-weight="%s">`, fontSize, weight)
+	y := height/2 + fontSize/2 - 1
+	fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, labelWidth/2, y, html.EscapeString(label))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" fill="#333">%s</text>`, labelWidth+messageWidth/2, y, html.EscapeString(message))
+	b.WriteString(`</g></svg>`)
+	return b.String()
+}
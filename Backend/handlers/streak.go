@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// how often every user's streak is recomputed, so streaks break for users
+// who let a release window pass without submitting
+const streakRefreshInterval = 15 * time.Minute
+
+type streakStats struct {
+	Current  int
+	Longest  int
+	FirstTry int
+}
+
+type levelRelease struct {
+	LevelID     int
+	ReleaseTime time.Time
+}
+
+type levelSolve struct {
+	TimeTaken time.Duration
+	Attempts  int
+	Passed    bool
+	SolvedAt  time.Time // the last submission, the solve once passed
+}
+
+// computeStreaks works out a user's streaks from their submissions. levels
+// are the released levels in release order, one per day. A day counts
+// towards the daily streak when its level was solved within window of its
+// release; the current streak is the run ending at the latest level, which
+// isn't broken yet while that level's window is still open. The first-try
+// streak counts consecutive attempted levels passed on the first attempt.
+func computeStreaks(levels []levelRelease, solves map[int]levelSolve, window time.Duration, now time.Time) streakStats {
+	var stats streakStats
+
+	ok := make([]bool, len(levels))
+	run := 0
+	for i, l := range levels {
+		s, attempted := solves[l.LevelID]
+		ok[i] = attempted && s.Passed && s.TimeTaken <= window
+		if ok[i] {
+			run++
+			stats.Longest = max(stats.Longest, run)
+		} else {
+			run = 0
+		}
+
+		if attempted {
+			if s.Passed && s.Attempts == 1 {
+				stats.FirstTry++
+			} else {
+				stats.FirstTry = 0
+			}
+		}
+	}
+
+	end := len(levels)
+	for end > 0 && !ok[end-1] && now.Before(levels[end-1].ReleaseTime.Add(window)) {
+		end--
+	}
+	for i := end - 1; i >= 0 && ok[i]; i-- {
+		stats.Current++
+	}
+
+	return stats
+}
+
+// streakReachedAt reports when a daily streak of days first ran, counted
+// the way computeStreaks counts them: the latest solve among the first days
+// levels in a row that were each solved within window of their release.
+func streakReachedAt(levels []levelRelease, solves map[int]levelSolve, window time.Duration, days int) (time.Time, bool) {
+	run := 0
+	for i, l := range levels {
+		s, attempted := solves[l.LevelID]
+		if !attempted || !s.Passed || s.TimeTaken > window {
+			run = 0
+			continue
+		}
+		run++
+		if run == days {
+			var at time.Time
+			for _, r := range levels[i-days+1 : i+1] {
+				if solved := solves[r.LevelID].SolvedAt; solved.After(at) {
+					at = solved
+				}
+			}
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func releasedLevels(ctx context.Context, q querier) ([]levelRelease, error) {
+	rows, err := q.Query(ctx, `
+	    SELECT level_id, release_time FROM levels
+	    WHERE release_time <= NOW()
+	    ORDER BY release_time
+	    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var levels []levelRelease
+	for rows.Next() {
+		var l levelRelease
+		if err := rows.Scan(&l.LevelID, &l.ReleaseTime); err != nil {
+			return nil, err
+		}
+		levels = append(levels, l)
+	}
+	return levels, rows.Err()
+}
+
+// recomputeStreak updates a single user's streaks, typically inside the
+// submission transaction so they match what was just written.
+func recomputeStreak(ctx context.Context, q querier, githubID int64) error {
+	levels, err := releasedLevels(ctx, q)
+	if err != nil {
+		return fmt.Errorf("error fetching released levels: %v", err)
+	}
+	return updateStreak(ctx, q, githubID, levels)
+}
+
+// userSolves fetches a user's submissions keyed by level.
+func userSolves(ctx context.Context, q querier, githubID int64) (map[int]levelSolve, error) {
+	rows, err := q.Query(ctx, `
+	    SELECT level_id, COALESCE(time_taken, INTERVAL '0'), attempts, passed, last_submission
+	    FROM submissions
+	    WHERE github_id = $1
+	    `, githubID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching submissions: %v", err)
+	}
+	defer rows.Close()
+
+	solves := map[int]levelSolve{}
+	for rows.Next() {
+		var level int
+		var s levelSolve
+		if err := rows.Scan(&level, &s.TimeTaken, &s.Attempts, &s.Passed, &s.SolvedAt); err != nil {
+			return nil, fmt.Errorf("error scanning submissions: %v", err)
+		}
+		solves[level] = s
+	}
+	return solves, rows.Err()
+}
+
+func updateStreak(ctx context.Context, q querier, githubID int64, levels []levelRelease) error {
+	solves, err := userSolves(ctx, q, githubID)
+	if err != nil {
+		return err
+	}
+
+	stats := computeStreaks(levels, solves, globals.StreakWindow, time.Now())
+	_, err = q.Exec(ctx, `
+	    UPDATE users SET
+	    streak = $1,
+	    longest_streak = $2,
+	    first_try_streak = $3
+	    WHERE github_id = $4
+	    `, stats.Current, stats.Longest, stats.FirstTry, githubID)
+	if err != nil {
+		return fmt.Errorf("error updating streaks: %v", err)
+	}
+	return nil
+}
+
+// RecomputeAllStreaks rebuilds every user's streaks from submission data.
+// Each user is recomputed in their own transaction holding their user row,
+// the lock a submission takes first, so a submission committed meanwhile is
+// either read here or recomputes the streak itself afterwards.
+func RecomputeAllStreaks(ctx context.Context) error {
+	levels, err := releasedLevels(ctx, globals.DB)
+	if err != nil {
+		return fmt.Errorf("error fetching released levels: %v", err)
+	}
+
+	var ids []int64
+	rows, err := globals.DB.Query(ctx, `SELECT github_id FROM users`)
+	if err != nil {
+		return fmt.Errorf("error fetching users: %v", err)
+	}
+	for rows.Next() {
+		var githubID int64
+		if err := rows.Scan(&githubID); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, githubID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, githubID := range ids {
+		if err := recomputeLockedStreak(ctx, githubID, levels); err != nil {
+			return fmt.Errorf("error recomputing the streak of %d: %v", githubID, err)
+		}
+	}
+
+	log.Printf("Recomputed streaks for %d users", len(ids))
+	return nil
+}
+
+func recomputeLockedStreak(ctx context.Context, githubID int64, levels []levelRelease) error {
+	tx, err := globals.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT 1 FROM users WHERE github_id = $1 FOR UPDATE`, githubID)
+	if err != nil {
+		return err
+	}
+	if err := updateStreak(ctx, tx, githubID, levels); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// WatchStreaks recomputes every streak on startup and then periodically.
+func WatchStreaks(ctx context.Context) {
+	ticker := time.NewTicker(streakRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := RecomputeAllStreaks(ctx); err != nil {
+			log.Printf("error recomputing streaks, %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeStreaks(t *testing.T) {
+	const window = 24 * time.Hour
+	start := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	levels := []levelRelease{
+		{1, start},
+		{2, start.Add(24 * time.Hour)},
+		{3, start.Add(48 * time.Hour)},
+		{4, start.Add(72 * time.Hour)},
+	}
+	// two hours into level 4's window, and after it closed
+	open := start.Add(74 * time.Hour)
+	closed := start.Add(97 * time.Hour)
+
+	solved := func(taken time.Duration, attempts int) levelSolve {
+		return levelSolve{TimeTaken: taken, Attempts: attempts, Passed: true}
+	}
+	firstTry := solved(time.Hour, 1)
+
+	tests := []struct {
+		name   string
+		levels []levelRelease
+		solves map[int]levelSolve
+		now    time.Time
+		want   streakStats
+	}{
+		{"nothing released", nil, nil, open, streakStats{}},
+		{"nothing solved", levels, nil, open, streakStats{}},
+		{"every level", levels, map[int]levelSolve{
+			1: firstTry, 2: firstTry, 3: firstTry, 4: firstTry,
+		}, open, streakStats{Current: 4, Longest: 4, FirstTry: 4}},
+		{"solved as the window closes", levels, map[int]levelSolve{
+			1: firstTry, 2: solved(window, 1), 3: firstTry, 4: firstTry,
+		}, open, streakStats{Current: 4, Longest: 4, FirstTry: 4}},
+		{"solved after the window", levels, map[int]levelSolve{
+			1: firstTry, 2: solved(window+time.Second, 1), 3: firstTry, 4: firstTry,
+		}, open, streakStats{Current: 2, Longest: 2, FirstTry: 4}},
+		{"today still open", levels, map[int]levelSolve{
+			1: firstTry, 2: firstTry, 3: firstTry,
+		}, open, streakStats{Current: 3, Longest: 3, FirstTry: 3}},
+		{"today attempted and still open", levels, map[int]levelSolve{
+			1: firstTry, 2: firstTry, 3: firstTry, 4: {Attempts: 2},
+		}, open, streakStats{Current: 3, Longest: 3, FirstTry: 0}},
+		{"today closed unsolved", levels, map[int]levelSolve{
+			1: firstTry, 2: firstTry, 3: firstTry,
+		}, closed, streakStats{Current: 0, Longest: 3, FirstTry: 3}},
+		{"gap", levels, map[int]levelSolve{
+			1: firstTry, 3: firstTry, 4: firstTry,
+		}, open, streakStats{Current: 2, Longest: 2, FirstTry: 3}},
+		{"first try reset", levels, map[int]levelSolve{
+			1: firstTry, 2: solved(time.Hour, 3), 3: firstTry, 4: firstTry,
+		}, open, streakStats{Current: 4, Longest: 4, FirstTry: 2}},
+		{"first try reset by a failed attempt", levels, map[int]levelSolve{
+			1: firstTry, 2: firstTry, 3: {Attempts: 1}, 4: firstTry,
+		}, open, streakStats{Current: 1, Longest: 2, FirstTry: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeStreaks(tt.levels, tt.solves, window, tt.now)
+			if got != tt.want {
+				t.Errorf("computeStreaks = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreakReachedAt(t *testing.T) {
+	const window = 24 * time.Hour
+	start := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	var levels []levelRelease
+	for i := range 4 {
+		levels = append(levels, levelRelease{i + 1, start.Add(time.Duration(i) * 24 * time.Hour)})
+	}
+	solved := func(level int, taken time.Duration) levelSolve {
+		return levelSolve{TimeTaken: taken, Attempts: 1, Passed: true, SolvedAt: levels[level-1].ReleaseTime.Add(taken)}
+	}
+
+	tests := []struct {
+		name   string
+		solves map[int]levelSolve
+		want   time.Time
+		ok     bool
+	}{
+		{"too short", map[int]levelSolve{
+			1: solved(1, time.Hour), 2: solved(2, time.Hour),
+		}, time.Time{}, false},
+		{"broken by a late solve", map[int]levelSolve{
+			1: solved(1, time.Hour), 2: solved(2, window+time.Hour), 3: solved(3, time.Hour), 4: solved(4, time.Hour),
+		}, time.Time{}, false},
+		{"reached on the third day", map[int]levelSolve{
+			1: solved(1, time.Hour), 2: solved(2, time.Hour), 3: solved(3, 2*time.Hour), 4: solved(4, time.Hour),
+		}, levels[2].ReleaseTime.Add(2 * time.Hour), true},
+		{"after a gap", map[int]levelSolve{
+			1: solved(1, time.Hour), 3: solved(3, time.Hour), 4: solved(4, time.Hour),
+		}, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := streakReachedAt(levels, tt.solves, window, 3)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("streakReachedAt = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
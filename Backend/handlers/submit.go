@@ -2,12 +2,10 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 
 	pgx "github.com/jackc/pgx/v5"
@@ -31,7 +29,6 @@ func SubmitAnswerHandler(tpl *template.Template) http.HandlerFunc {
 			http.Error(w, "Invalid url request", http.StatusBadRequest)
 			return
 		}
-		newSlug := fmt.Sprintf("level%d", level)
 
 		// Get session info from DB
 		sdata := ctx.Value("sessionData").(globals.SessionData)
@@ -56,8 +53,7 @@ func SubmitAnswerHandler(tpl *template.Template) http.HandlerFunc {
 			return
 		}
 
-		answerFile := fmt.Sprintf("./puzzles/%s/problem_set/%d.json", newSlug, sdata.InputID)
-		b, err := os.ReadFile(answerFile)
+		problemSet, err := readProblemSet(level, sdata.InputID)
 		if err != nil {
 			globals.RenderInfoPage(tpl, w, true, map[string]any{
 				"Unexpected": true,
@@ -65,8 +61,6 @@ func SubmitAnswerHandler(tpl *template.Template) http.HandlerFunc {
 			log.Printf("Correct answer file not found! %v\n", err)
 			return
 		}
-		var problemSet globals.ProblemSet
-		json.Unmarshal(b, &problemSet)
 
 		submissionData.CurrentLevel = sdata.CurrentLevel
 		submissionData.GithubID = sdata.GithubID
@@ -126,6 +120,14 @@ func updateUserAttempt(ctx context.Context, submissionData globals.SubmissionDat
 	}
 	defer tx.Rollback(ctx)
 
+	// the user row is locked before any submission is touched, the same
+	// order RecomputeAllStreaks takes, so the two can't overwrite each
+	// other's streaks or deadlock
+	_, err = tx.Exec(ctx, `SELECT 1 FROM users WHERE github_id = $1 FOR UPDATE`, submissionData.GithubID)
+	if err != nil {
+		return globals.SubmissionError, fmt.Errorf("error locking user: %v", err)
+	}
+
 	if submissionData.Pass {
 		if submissionData.PuzzleLevel > submissionData.CurrentLevel {
 			return globals.LevelIncomplete, fmt.Errorf("Level %d not completed yet", submissionData.CurrentLevel)
@@ -209,27 +211,20 @@ func submissionTx(ctx context.Context, tx pgx.Tx, submissionData globals.Submiss
 			return globals.SubmissionError, fmt.Errorf("error updating submission as passed, %v", err)
 		}
 
-		if attempts == 1 {
-			_, err := tx.Exec(ctx, `
-                UPDATE users SET
-                streak = streak + 1
-                WHERE github_id = $1 `, submissionData.GithubID)
-			if err != nil {
-				return globals.SubmissionError, fmt.Errorf("errror updating streak %v", err)
-			}
+		err = recomputeStreak(ctx, tx, submissionData.GithubID)
+		if err != nil {
+			return globals.SubmissionError, fmt.Errorf("errror updating streak %v", err)
 		}
 		err = tx.Commit(ctx)
 		if err != nil {
 			return globals.SubmissionError, fmt.Errorf("failed to commit transaction: %v", err)
 		}
+		awardBadges(ctx, submissionData)
 		return globals.LevelPassed, nil
 	} else {
-		// user had failed on first attempt, set the streak to zero
-		_, err := tx.Exec(ctx, `
-                UPDATE users SET
-                streak = 0
-                WHERE github_id = $1
-                `, submissionData.GithubID)
+		// a wrong answer doesn't break the daily streak, but it does end the
+		// first-try streak
+		err := recomputeStreak(ctx, tx, submissionData.GithubID)
 		if err != nil {
 			return globals.SubmissionError, fmt.Errorf("Error setting streak : %v", err)
 		}
@@ -237,6 +232,20 @@ func submissionTx(ctx context.Context, tx pgx.Tx, submissionData globals.Submiss
 		if err != nil {
 			return globals.SubmissionError, fmt.Errorf("failed to commit transaction: %v", err)
 		}
+		awardBadges(ctx, submissionData)
 		return globals.LevelFailed, nil
 	}
 }
+
+// awardBadges runs the achievement rules once a submission is committed. A
+// failure here only delays the badge until the next submission or backfill,
+// so it's logged rather than failing the submission.
+func awardBadges(ctx context.Context, submissionData globals.SubmissionData) {
+	awarded, err := evaluateBadges(ctx, submissionData.GithubID)
+	if err != nil {
+		log.Printf("error evaluating badges for %s, %v", submissionData.Username, err)
+	}
+	for _, slug := range awarded {
+		log.Printf("Awarded badge %s to %s", slug, submissionData.Username)
+	}
+}
@@ -0,0 +1,618 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+const TeamRune = "team"
+
+var (
+	errTeamsLocked   = errors.New("team membership is locked once the event starts")
+	errTeamFull      = errors.New("team is full")
+	errAlreadyInTeam = errors.New("already in a team")
+	errTeamNameTaken = errors.New("team name is taken")
+)
+
+type team struct {
+	TeamID     int
+	Name       string
+	OwnerID    int64
+	InviteCode string
+	CreatedAt  time.Time
+}
+
+type teamMember struct {
+	GithubID  int64
+	Username  string
+	GithubUrl string
+	Solved    int
+}
+
+type teamLevel struct {
+	LevelId   int
+	Username  string
+	TimeTaken time.Duration
+}
+
+// TeamsHandler shows the user's own team, or the forms to create one when
+// they aren't in any.
+func TeamsHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !globals.TeamMode {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		teamID, err := userTeamID(ctx, sdata.GithubID)
+		if err != nil {
+			log.Printf("error fetching the team of %s, %v", sdata.Username, err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+		if teamID != 0 {
+			http.Redirect(w, r, fmt.Sprintf("/teams/%d", teamID), http.StatusSeeOther)
+			return
+		}
+
+		locked, err := eventStarted(ctx)
+		if err != nil {
+			log.Printf("error checking the event start, %v", err)
+		}
+
+		tpl.ExecuteTemplate(w, "teams", map[string]any{
+			"LoggedIn": true,
+			"Teams":    true,
+			"Locked":   locked,
+			"MaxSize":  globals.TeamMaxSize,
+		})
+	}
+}
+
+// TeamHandler is the public page of a team: its members, standing and the
+// fastest member solve of every level.
+func TeamHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !globals.TeamMode {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		teamID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		t, err := fetchTeam(ctx, teamID)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				log.Printf("error fetching team %d, %v", teamID, err)
+			}
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		// the members' solves, the levels and the standing all come from
+		// the same tables, so a frozen board doesn't leak through here
+		frozen := leaderboardFrozen(ctx)
+		members, err := teamMembers(ctx, teamID, frozen)
+		if err != nil {
+			log.Printf("error fetching members of team %d, %v", teamID, err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		levels, err := teamLevels(ctx, teamID, frozen)
+		if err != nil {
+			log.Printf("error fetching levels of team %d, %v", teamID, err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		var rank, score int
+		err = globals.DB.QueryRow(ctx, leaderboardTables(`
+		    WITH ranked AS (`+teamRankedQuery(3)+`)
+		    SELECT rank, score FROM ranked WHERE github_id = $2
+		    `, frozen), levelPoints, teamID, 0).Scan(&rank, &score)
+		if err != nil {
+			log.Printf("error fetching the standing of team %d, %v", teamID, err)
+		}
+
+		isMember := false
+		for _, m := range members {
+			isMember = isMember || m.GithubID == sdata.GithubID
+		}
+		locked, err := eventStarted(ctx)
+		if err != nil {
+			log.Printf("error checking the event start, %v", err)
+		}
+
+		tpl.ExecuteTemplate(w, "teams", map[string]any{
+			"LoggedIn":    true,
+			"Team":        t,
+			"TeamMembers": members,
+			"TeamLevels":  levels,
+			"Rank":        rank,
+			"Score":       score,
+			"IsMember":    isMember,
+			"IsOwner":     t.OwnerID == sdata.GithubID,
+			"Locked":      locked,
+			"MaxSize":     globals.TeamMaxSize,
+			"InviteURL":   fmt.Sprintf("http://%s:%s/teams/join/%s", globals.Hostname, globals.Port, t.InviteCode),
+		})
+	}
+}
+
+func CreateTeamHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !globals.TeamMode {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || len(name) > 64 {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		teamID, err := createTeam(ctx, name, sdata.GithubID)
+		if err != nil {
+			renderTeamError(tpl, w, err)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/teams/%d", teamID), http.StatusSeeOther)
+	}
+}
+
+// JoinTeamHandler is where invite links point. A GET asks for confirmation,
+// the POST from that page does the joining.
+func JoinTeamHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !globals.TeamMode {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+		code := r.PathValue("code")
+
+		var t team
+		err := globals.DB.QueryRow(ctx, `
+		    SELECT team_id, name FROM teams WHERE invite_code = $1
+		    `, code).Scan(&t.TeamID, &t.Name)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				log.Printf("error looking up invite code, %v", err)
+			}
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			tpl.ExecuteTemplate(w, "teams", map[string]any{
+				"LoggedIn":   true,
+				"TeamInvite": t,
+				"Code":       code,
+			})
+			return
+		}
+
+		err = joinTeam(ctx, t.TeamID, sdata.GithubID)
+		if err != nil {
+			renderTeamError(tpl, w, err)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/teams/%d", t.TeamID), http.StatusSeeOther)
+	}
+}
+
+// LeaveTeamHandler removes the user from their team. An owner leaving hands
+// the team to its longest standing member, or disbands it if they were alone.
+func LeaveTeamHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !globals.TeamMode {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		err := leaveTeam(ctx, sdata.GithubID)
+		if err != nil {
+			renderTeamError(tpl, w, err)
+			return
+		}
+
+		http.Redirect(w, r, "/teams", http.StatusSeeOther)
+	}
+}
+
+func renderTeamError(tpl *template.Template, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTeamsLocked):
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"TeamsLocked": true,
+		})
+	case errors.Is(err, errTeamFull):
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"TeamFull": true,
+			"MaxSize":  globals.TeamMaxSize,
+		})
+	case errors.Is(err, errAlreadyInTeam):
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"AlreadyInTeam": true,
+		})
+	case errors.Is(err, errTeamNameTaken):
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"TeamNameTaken": true,
+		})
+	default:
+		log.Printf("team error, %v", err)
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"Unexpected": true,
+		})
+	}
+}
+
+// eventStarted reports whether the first level has been released, after
+// which team membership is locked.
+func eventStarted(ctx context.Context) (bool, error) {
+	var started bool
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT COALESCE(MIN(release_time) <= NOW(), FALSE) FROM levels
+	    `).Scan(&started)
+	return started, err
+}
+
+func userTeamID(ctx context.Context, githubID int64) (int, error) {
+	var teamID int
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT team_id FROM team_members WHERE github_id = $1
+	    `, githubID).Scan(&teamID)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return teamID, err
+}
+
+func createTeam(ctx context.Context, name string, ownerID int64) (int, error) {
+	locked, err := eventStarted(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if locked {
+		return 0, errTeamsLocked
+	}
+
+	teamID, err := userTeamID(ctx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	if teamID != 0 {
+		return 0, errAlreadyInTeam
+	}
+
+	err = withJoinCode("teams_invite_code_key", func(code string) error {
+		return globals.DB.QueryRow(ctx, `
+		    WITH team AS (
+		        INSERT INTO teams (name, owner_id, invite_code)
+		        VALUES ($1, $2, $3)
+		        RETURNING team_id
+		    )
+		    INSERT INTO team_members (team_id, github_id)
+		    SELECT team_id, $2 FROM team
+		    RETURNING team_id
+		    `, name, ownerID, code).Scan(&teamID)
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "teams_name_key":
+			return 0, errTeamNameTaken
+		case "team_members_github_id_key":
+			return 0, errAlreadyInTeam
+		}
+	}
+	return teamID, err
+}
+
+func joinTeam(ctx context.Context, teamID int, githubID int64) error {
+	locked, err := eventStarted(ctx)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return errTeamsLocked
+	}
+
+	tx, err := globals.DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// lock the team row so concurrent joins can't overfill it
+	var members int
+	err = tx.QueryRow(ctx, `
+	    SELECT (SELECT COUNT(*) FROM team_members WHERE team_id = t.team_id)
+	    FROM teams t
+	    WHERE t.team_id = $1
+	    FOR UPDATE
+	    `, teamID).Scan(&members)
+	if err != nil {
+		return err
+	}
+	if members >= globals.TeamMaxSize {
+		return errTeamFull
+	}
+
+	_, err = tx.Exec(ctx, `
+	    INSERT INTO team_members (team_id, github_id)
+	    VALUES ($1, $2)
+	    `, teamID, githubID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return errAlreadyInTeam
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func leaveTeam(ctx context.Context, githubID int64) error {
+	locked, err := eventStarted(ctx)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return errTeamsLocked
+	}
+
+	tx, err := globals.DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var teamID int
+	err = tx.QueryRow(ctx, `
+	    DELETE FROM team_members WHERE github_id = $1
+	    RETURNING team_id
+	    `, githubID).Scan(&teamID)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+	    UPDATE teams SET owner_id = (
+	        SELECT github_id FROM team_members
+	        WHERE team_id = $1
+	        ORDER BY joined_at
+	        LIMIT 1
+	    )
+	    WHERE team_id = $1 AND owner_id = $2
+	    AND EXISTS (SELECT 1 FROM team_members WHERE team_id = $1)
+	    `, teamID, githubID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+	    DELETE FROM teams
+	    WHERE team_id = $1
+	    AND NOT EXISTS (SELECT 1 FROM team_members WHERE team_id = $1)
+	    `, teamID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func fetchTeam(ctx context.Context, teamID int) (team, error) {
+	var t team
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT team_id, name, owner_id, invite_code, created_at
+	    FROM teams WHERE team_id = $1
+	    `, teamID).Scan(&t.TeamID, &t.Name, &t.OwnerID, &t.InviteCode, &t.CreatedAt)
+	return t, err
+}
+
+// teamMembers lists the team's members with how many levels each solved,
+// as of the freeze when frozen.
+func teamMembers(ctx context.Context, teamID int, frozen bool) ([]teamMember, error) {
+	rows, err := globals.DB.Query(ctx, leaderboardTables(`
+	    SELECT u.github_id, u.username, COALESCE(u.github_url, ''),
+	        (SELECT COUNT(*) FROM {submissions} s WHERE s.github_id = u.github_id AND s.passed = TRUE)
+	    FROM team_members m
+	    JOIN users u ON u.github_id = m.github_id
+	    WHERE m.team_id = $1
+	    ORDER BY m.joined_at
+	    `, frozen), teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []teamMember
+	for rows.Next() {
+		var m teamMember
+		if err := rows.Scan(&m.GithubID, &m.Username, &m.GithubUrl, &m.Solved); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// teamLevels lists every level the team has solved with its fastest member,
+// as of the freeze when frozen.
+func teamLevels(ctx context.Context, teamID int, frozen bool) ([]teamLevel, error) {
+	rows, err := globals.DB.Query(ctx, leaderboardTables(`
+	    SELECT DISTINCT ON (s.level_id) s.level_id, s.username, `+rankedTime("s")+` AS time_taken
+	    FROM {submissions} s
+	    JOIN team_members m ON m.github_id = s.github_id
+	    WHERE m.team_id = $1 AND s.passed = TRUE
+	    ORDER BY s.level_id, time_taken ASC
+	    `, frozen), teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var levels []teamLevel
+	for rows.Next() {
+		var l teamLevel
+		if err := rows.Scan(&l.LevelId, &l.Username, &l.TimeTaken); err != nil {
+			return nil, err
+		}
+		levels = append(levels, l)
+	}
+	return levels, rows.Err()
+}
+
+// teamRankedQuery ranks teams for the team rune, with levelPoints bound at
+// $1. In union scoring a team is ranked on how many levels any member
+// solved, ties broken by the summed fastest member times; in best scoring on
+// the points of its best member on every level. team_id and name are exposed
+// as github_id and username so rankedPage can pin and search them. With a
+// private board bound at $board only its members count towards their teams,
+// and teams without any are left out.
+func teamRankedQuery(board int) string {
+	order := "solved DESC, total_time ASC"
+	key := "-solved, EXTRACT(EPOCH FROM total_time)"
+	if globals.TeamScoring == globals.TeamScoringBest {
+		order = "score DESC"
+		key = "-score"
+	}
+
+	return fmt.Sprintf(`
+	    SELECT team_id AS github_id, name AS username, members, solved, score, total_time,
+	        RANK() OVER (ORDER BY %[1]s) AS rank,
+	        ARRAY[%[3]s, team_id]::NUMERIC[] AS sort_key
+	    FROM (
+	        SELECT t.team_id, t.name,
+	            (SELECT COUNT(*) FROM team_members c
+	                WHERE c.team_id = t.team_id AND %[4]s) AS members,
+	            COUNT(best.level_id) AS solved,
+	            COALESCE(SUM(best.points), 0)::INT AS score,
+	            COALESCE(SUM(best.time_taken), INTERVAL '0') AS total_time
+	        FROM teams t
+	        LEFT JOIN (
+	            SELECT m.team_id, p.level_id, MAX(p.points) AS points, MIN(p.time_taken) AS time_taken
+	            FROM team_members m
+	            JOIN (%[2]s) p ON p.github_id = m.github_id
+	            WHERE %[5]s
+	            GROUP BY m.team_id, p.level_id
+	        ) best ON best.team_id = t.team_id
+	        WHERE $%[6]d = 0 OR t.team_id IN (
+	            SELECT b.team_id FROM team_members b WHERE %[7]s)
+	        GROUP BY t.team_id
+	    ) standings
+	    `, order, levelPointsQuery(1), key, boardFilter("c.github_id", board),
+		boardFilter("m.github_id", board), board, boardFilter("b.github_id", board))
+}
+
+func teamHandler(ctx context.Context, q leaderboardQuery) (leaderboardPage, error) {
+	type Record struct {
+		Rank      int           `json:"rank"`
+		TeamID    int64         `json:"team_id"`
+		Name      string        `json:"name"`
+		Members   int           `json:"members"`
+		Solved    int           `json:"solved"`
+		Score     int           `json:"score"`
+		TotalTime time.Duration `json:"total_time"`
+		IsMine    bool          `json:"-"`
+	}
+
+	// pin the viewer's team rather than the viewer
+	if q.ViewerID != 0 {
+		teamID, err := userTeamID(ctx, q.ViewerID)
+		if err != nil {
+			log.Printf("error fetching the team of %d, %v", q.ViewerID, err)
+		}
+		q.ViewerID = int64(teamID)
+	}
+
+	data, mine, prev, next, err := rankedPage(ctx, teamRankedQuery(2),
+		"sort_key::TEXT, rank, github_id, username, members, solved, score, total_time", q,
+		func(row rowScanner) (Record, string, error) {
+			var rec Record
+			var key string
+			err := row.Scan(&key, &rec.Rank, &rec.TeamID, &rec.Name, &rec.Members, &rec.Solved, &rec.Score, &rec.TotalTime)
+			rec.IsMine = q.ViewerID != 0 && rec.TeamID == q.ViewerID
+			return rec, key, err
+		}, levelPoints, q.Board)
+	if err != nil {
+		log.Printf("error fetching the Team leaderboard, %v", err)
+		return leaderboardPage{}, err
+	}
+
+	page := leaderboardPage{Rune: TeamRune, Rows: data, Prev: prev, Next: next, Frozen: q.Frozen}
+	if mine != nil {
+		page.Mine = mine
+	}
+	return page, nil
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// problem sets expected past the highest input_id handed out so far, so the
+// users who sign up during the event are covered too
+const inputHeadroom = 100
+
+// ValidatePuzzles checks every level's files and returns the problems
+// found. An error means the check itself couldn't run.
+func ValidatePuzzles(ctx context.Context) ([]string, error) {
+	var maxInputID int
+	err := globals.DB.QueryRow(ctx, `SELECT COALESCE(MAX(input_id), 0) FROM users`).Scan(&maxInputID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching input ids: %v", err)
+	}
+
+	rows, err := globals.DB.Query(ctx, `SELECT level_id FROM levels ORDER BY level_id`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching levels: %v", err)
+	}
+	levels := map[int]bool{}
+	for rows.Next() {
+		var level int
+		if err := rows.Scan(&level); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		levels[level] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// level directories without a levels row are checked as well
+	dirs, err := globals.Puzzles.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if m := levelDirPattern.FindStringSubmatch(dir.Name()); dir.IsDir() && m != nil {
+			level, _ := strconv.Atoi(m[1])
+			levels[level] = true
+		}
+	}
+
+	ordered := make([]int, 0, len(levels))
+	for level := range levels {
+		ordered = append(ordered, level)
+	}
+	sort.Ints(ordered)
+
+	files, err := puzzleFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// the validate command opens sealed levels through the early unlock, so
+	// the ones that still can't be read have no key or aren't scheduled
+	var issues []string
+	for _, level := range ordered {
+		_, err := globals.Puzzles.ReadDir(fmt.Sprintf("level%d", level))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			issues = append(issues, fmt.Sprintf("level%d: can't be read: %v", level, err))
+			continue
+		}
+		issues = append(issues, validateLevel(level, files, maxInputID+inputHeadroom)...)
+	}
+	return issues, nil
+}
+
+// validateLevel checks a level's markdown, hints and problem sets 1 to
+// inputs.
+func validateLevel(level int, files map[puzzleKey]string, inputs int) []string {
+	var issues []string
+	report := func(format string, args ...any) {
+		issues = append(issues, fmt.Sprintf("level%d: ", level)+fmt.Sprintf(format, args...))
+	}
+
+	if _, ok := files[puzzleKey{level, 1}]; !ok {
+		report("missing level%d.md", level)
+	}
+	var parts []int
+	for key := range files {
+		if key.Level == level {
+			parts = append(parts, key.Part)
+		}
+	}
+	sort.Ints(parts)
+	for _, part := range parts {
+		name := files[puzzleKey{level, part}]
+		if _, err := renderPuzzleFile(name); err != nil {
+			report("%s doesn't render: %v", path.Base(name), err)
+		}
+		if _, ok := files[puzzleKey{level, part - 1}]; part > 1 && !ok {
+			report("%s comes after a missing part", path.Base(name))
+		}
+	}
+
+	if _, err := loadHints(level); err != nil {
+		report("%v", err)
+	}
+
+	if _, err := globals.Puzzles.Stat(fmt.Sprintf("level%d/problem_set", level)); err != nil {
+		report("missing problem_set directory")
+		return issues
+	}
+
+	var missing []int
+	seenInputs := map[string]int{}
+	seenOutputs := map[string]int{}
+	for id := 1; id <= inputs; id++ {
+		b, err := globals.Puzzles.ReadFile(fmt.Sprintf("level%d/problem_set/%d.json", level, id))
+		if err != nil {
+			missing = append(missing, id)
+			continue
+		}
+
+		var problemSet globals.ProblemSet
+		if err := json.Unmarshal(b, &problemSet); err != nil {
+			report("problem set %d doesn't parse: %v", id, err)
+			continue
+		}
+
+		// answers are compared trimmed and come from a single line field
+		output := strings.TrimSpace(problemSet.Output)
+		switch {
+		case strings.TrimSpace(problemSet.Input) == "":
+			report("problem set %d has an empty input", id)
+		case seenInputs[problemSet.Input] != 0:
+			report("problem set %d has the same input as %d", id, seenInputs[problemSet.Input])
+		default:
+			seenInputs[problemSet.Input] = id
+		}
+		switch {
+		case output == "":
+			report("problem set %d has an empty output", id)
+		case strings.ContainsAny(output, "\r\n"):
+			report("problem set %d has a multi-line output that can't be submitted", id)
+		case seenOutputs[output] != 0:
+			report("problem set %d has the same output as %d", id, seenOutputs[output])
+		default:
+			seenOutputs[output] = id
+		}
+	}
+
+	if len(missing) > 0 {
+		report("missing problem sets %s", idRanges(missing))
+	}
+	return issues
+}
+
+// idRanges formats sorted ids as "1-3, 7".
+func idRanges(ids []int) string {
+	var ranges []string
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(ids[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", ids[i], ids[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sceptix-club/atlus/Backend/content"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// goodLevel is a level that passes validation with 3 inputs.
+func goodLevel() fstest.MapFS {
+	return fstest.MapFS{
+		"level1/level1.md":          {Data: []byte("# One\n\n{{input-preview}}\n")},
+		"level1/part2.md":           {Data: []byte("# One, again\n")},
+		"level1/hints.json":         {Data: []byte(`[{"text": "Look closer", "unlock_after": "1h"}]`)},
+		"level1/problem_set/1.json": {Data: []byte(`{"input": "1 2", "output": "3"}`)},
+		"level1/problem_set/2.json": {Data: []byte(`{"input": "2 2", "output": "4"}`)},
+		"level1/problem_set/3.json": {Data: []byte(`{"input": "2 3", "output": "5\n"}`)},
+	}
+}
+
+func TestValidateLevel(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(fstest.MapFS)
+		want   []string
+	}{
+		{"good", func(fstest.MapFS) {}, nil},
+		{"missing markdown", func(m fstest.MapFS) {
+			delete(m, "level1/level1.md")
+		}, []string{"level1: missing level1.md", "level1: part2.md comes after a missing part"}},
+		{"gap in parts", func(m fstest.MapFS) {
+			m["level1/part4.md"] = &fstest.MapFile{Data: []byte("# Four")}
+		}, []string{"level1: part4.md comes after a missing part"}},
+		{"bad hints", func(m fstest.MapFS) {
+			m["level1/hints.json"] = &fstest.MapFile{Data: []byte(`[{"text": `)}
+		}, []string{"level1: error parsing hints for level 1: unexpected end of JSON input"}},
+		{"no problem sets", func(m fstest.MapFS) {
+			for name := range m {
+				if strings.HasPrefix(name, "level1/problem_set/") {
+					delete(m, name)
+				}
+			}
+		}, []string{"level1: missing problem_set directory"}},
+		{"missing problem sets", func(m fstest.MapFS) {
+			delete(m, "level1/problem_set/1.json")
+			delete(m, "level1/problem_set/2.json")
+		}, []string{"level1: missing problem sets 1-2"}},
+		{"unparsable problem set", func(m fstest.MapFS) {
+			m["level1/problem_set/2.json"] = &fstest.MapFile{Data: []byte(`{"input": 2}`)}
+		}, []string{"level1: problem set 2 doesn't parse: json: cannot unmarshal number into Go struct field ProblemSet.input of type string"}},
+		{"empty input", func(m fstest.MapFS) {
+			m["level1/problem_set/2.json"] = &fstest.MapFile{Data: []byte(`{"input": " \n", "output": "4"}`)}
+		}, []string{"level1: problem set 2 has an empty input"}},
+		{"duplicate input", func(m fstest.MapFS) {
+			m["level1/problem_set/3.json"] = &fstest.MapFile{Data: []byte(`{"input": "1 2", "output": "5"}`)}
+		}, []string{"level1: problem set 3 has the same input as 1"}},
+		{"empty output", func(m fstest.MapFS) {
+			m["level1/problem_set/2.json"] = &fstest.MapFile{Data: []byte(`{"input": "2 2", "output": ""}`)}
+		}, []string{"level1: problem set 2 has an empty output"}},
+		{"multi-line output", func(m fstest.MapFS) {
+			m["level1/problem_set/2.json"] = &fstest.MapFile{Data: []byte(`{"input": "2 2", "output": "4\n5"}`)}
+		}, []string{"level1: problem set 2 has a multi-line output that can't be submitted"}},
+		{"duplicate output", func(m fstest.MapFS) {
+			// compared trimmed, like submitted answers
+			m["level1/problem_set/3.json"] = &fstest.MapFile{Data: []byte(`{"input": "2 3", "output": " 3 "}`)}
+		}, []string{"level1: problem set 3 has the same output as 1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := goodLevel()
+			tt.change(m)
+			globals.Puzzles = content.FS(m)
+			files, err := puzzleFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := validateLevel(1, files, 3)
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("validateLevel =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDRanges(t *testing.T) {
+	tests := []struct {
+		ids  []int
+		want string
+	}{
+		{nil, ""},
+		{[]int{4}, "4"},
+		{[]int{1, 2}, "1-2"},
+		{[]int{1, 2, 3, 7}, "1-3, 7"},
+		{[]int{1, 3, 5}, "1, 3, 5"},
+		{[]int{2, 5, 6, 7, 9, 10}, "2, 5-7, 9-10"},
+	}
+	for _, tt := range tests {
+		if got := idRanges(tt.ids); got != tt.want {
+			t.Errorf("idRanges(%v) = %q, want %q", tt.ids, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/sceptix-club/atlus/Backend/globals"
+)
+
+// longest writeup body accepted, in bytes
+const writeupMaxLength = 20000
+
+type writeup struct {
+	WriteupID int64
+	GithubID  int64
+	Username  string
+	Body      template.HTML
+	CodeURL   string
+	UpdatedAt time.Time
+	Votes     int
+	Voted     bool
+}
+
+// writeupsOpenAt is when writeups of a level open: WRITEUP_WINDOW after its
+// release, or at EVENT_END_AT if that comes first.
+func writeupsOpenAt(ctx context.Context, level int) (time.Time, error) {
+	var release time.Time
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT release_time FROM levels WHERE level_id = $1
+	    `, level).Scan(&release)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	openAt := release.Add(globals.WriteupWindow)
+	if !globals.EventEnd.IsZero() && globals.EventEnd.Before(openAt) {
+		openAt = globals.EventEnd
+	}
+	return openAt, nil
+}
+
+// writeupLevel resolves the level of a writeup route and checks its writeups
+// are open, rendering the reason when they aren't.
+func writeupLevel(ctx context.Context, tpl *template.Template, w http.ResponseWriter, r *http.Request) (int, bool) {
+	level, err := getLevelParam(r.PathValue("slug"))
+	if err != nil {
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"InvalidRequest": true,
+		})
+		return 0, false
+	}
+
+	sdata := ctx.Value("sessionData").(globals.SessionData)
+	if sdata.NextReleaseLevel <= level {
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"NotReleased": true,
+			"NextLevel":   sdata.NextReleaseLevel,
+		})
+		return 0, false
+	}
+
+	openAt, err := writeupsOpenAt(ctx, level)
+	if err != nil {
+		log.Printf("error fetching the writeup window, %v", err)
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"Unexpected": true,
+		})
+		return 0, false
+	}
+	if time.Now().Before(openAt) {
+		globals.RenderInfoPage(tpl, w, true, map[string]any{
+			"WriteupsClosed": true,
+			"OpenAt":         openAt,
+		})
+		return 0, false
+	}
+	return level, true
+}
+
+// WriteupsHandler lists the writeups of a level, most upvoted first, along
+// with the form for solvers to publish their own.
+func WriteupsHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		level, ok := writeupLevel(ctx, tpl, w, r)
+		if !ok {
+			return
+		}
+
+		writeups, err := levelWriteups(ctx, level, sdata.GithubID)
+		if err != nil {
+			log.Printf("error fetching writeups, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		passed, err := passedLevel(ctx, sdata.GithubID, level)
+		if err != nil {
+			log.Printf("error checking the submission, %v", err)
+		}
+
+		// prefill the form with the user's own writeup
+		var ownBody, ownCodeURL string
+		if passed {
+			err = globals.DB.QueryRow(ctx, `
+			    SELECT body, code_url FROM writeups
+			    WHERE github_id = $1 AND level_id = $2
+			    `, sdata.GithubID, level).Scan(&ownBody, &ownCodeURL)
+			if err != nil && err != pgx.ErrNoRows {
+				log.Printf("error fetching the writeup, %v", err)
+			}
+		}
+
+		err = tpl.ExecuteTemplate(w, "writeups", map[string]any{
+			"LoggedIn":   true,
+			"Writeups":   true,
+			"Slug":       fmt.Sprintf("level%d", level),
+			"LevelId":    level,
+			"List":       writeups,
+			"CanPublish": passed,
+			"OwnBody":    ownBody,
+			"OwnCodeURL": ownCodeURL,
+			"MaxLength":  writeupMaxLength,
+			"GithubID":   sdata.GithubID,
+		})
+		if err != nil {
+			log.Printf("error executing the template, %v", err)
+		}
+	}
+}
+
+// PublishWriteupHandler creates or updates the user's writeup of a level
+// they solved.
+func PublishWriteupHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		level, ok := writeupLevel(ctx, tpl, w, r)
+		if !ok {
+			return
+		}
+
+		passed, err := passedLevel(ctx, sdata.GithubID, level)
+		if err != nil {
+			log.Printf("error checking the submission, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		body := strings.TrimSpace(r.FormValue("body"))
+		codeURL := strings.TrimSpace(r.FormValue("code_url"))
+		if !passed || body == "" || len(body) > writeupMaxLength || !validCodeURL(codeURL) {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		_, err = globals.DB.Exec(ctx, `
+		    INSERT INTO writeups (github_id, level_id, body, code_url)
+		    VALUES ($1, $2, $3, $4)
+		    ON CONFLICT (github_id, level_id) DO UPDATE SET
+		        body = EXCLUDED.body,
+		        code_url = EXCLUDED.code_url,
+		        updated_at = NOW()
+		    `, sdata.GithubID, level, body, codeURL)
+		if err != nil {
+			log.Printf("error publishing the writeup, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/writeups/level%d", level), http.StatusSeeOther)
+	}
+}
+
+// VoteWriteupHandler toggles the user's upvote on a writeup. Authors can't
+// upvote their own.
+func VoteWriteupHandler(tpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		sdata := ctx.Value("sessionData").(globals.SessionData)
+
+		level, ok := writeupLevel(ctx, tpl, w, r)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"InvalidRequest": true,
+			})
+			return
+		}
+
+		tag, err := globals.DB.Exec(ctx, `
+		    DELETE FROM writeup_votes
+		    WHERE writeup_id = $1 AND github_id = $2
+		    `, id, sdata.GithubID)
+		if err == nil && tag.RowsAffected() == 0 {
+			_, err = globals.DB.Exec(ctx, `
+			    INSERT INTO writeup_votes (writeup_id, github_id)
+			    SELECT writeup_id, $2 FROM writeups
+			    WHERE writeup_id = $1 AND level_id = $3 AND github_id <> $2
+			    ON CONFLICT DO NOTHING
+			    `, id, sdata.GithubID, level)
+		}
+		if err != nil {
+			log.Printf("error voting on the writeup, %v", err)
+			globals.RenderInfoPage(tpl, w, true, map[string]any{
+				"Unexpected": true,
+			})
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/writeups/level%d#writeup-%d", level, id), http.StatusSeeOther)
+	}
+}
+
+func levelWriteups(ctx context.Context, level int, viewer int64) ([]writeup, error) {
+	rows, err := globals.DB.Query(ctx, `
+	    SELECT w.writeup_id, w.github_id, u.username, w.body, w.code_url, w.updated_at,
+	        COUNT(v.github_id) AS votes,
+	        COALESCE(BOOL_OR(v.github_id = $2), FALSE) AS voted
+	    FROM writeups w
+	    JOIN users u ON u.github_id = w.github_id
+	    LEFT JOIN writeup_votes v ON v.writeup_id = w.writeup_id
+	    WHERE w.level_id = $1
+	    GROUP BY w.writeup_id, u.username
+	    ORDER BY votes DESC, w.created_at
+	    `, level, viewer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var writeups []writeup
+	for rows.Next() {
+		var wu writeup
+		var body string
+		if err := rows.Scan(&wu.WriteupID, &wu.GithubID, &wu.Username, &body, &wu.CodeURL,
+			&wu.UpdatedAt, &wu.Votes, &wu.Voted); err != nil {
+			return nil, err
+		}
+		wu.Body, err = renderUserMarkdown([]byte(body))
+		if err != nil {
+			return nil, err
+		}
+		writeups = append(writeups, wu)
+	}
+	return writeups, rows.Err()
+}
+
+func passedLevel(ctx context.Context, githubID int64, level int) (bool, error) {
+	var passed bool
+	err := globals.DB.QueryRow(ctx, `
+	    SELECT EXISTS (
+	        SELECT 1 FROM submissions
+	        WHERE github_id = $1 AND level_id = $2 AND passed = TRUE
+	    )`, githubID, level).Scan(&passed)
+	return passed, err
+}
+
+// validCodeURL accepts an empty link or an absolute http(s) one.
+func validCodeURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
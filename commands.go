@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sceptix-club/atlus/Backend/content"
+	"github.com/sceptix-club/atlus/Backend/globals"
+	"github.com/sceptix-club/atlus/Backend/handlers"
+)
+
+// runOfflineCommand runs the commands that don't touch the database, before
+// connecting to it. ok is false for any other arguments.
+func runOfflineCommand(args []string) (code int, ok bool) {
+	if len(args) == 3 && args[0] == "puzzles" && args[1] == "pack" {
+		if err := packPuzzles(args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to pack puzzles: %v\n", err)
+			return 1, true
+		}
+		return 0, true
+	}
+	if len(args) == 3 && args[0] == "puzzles" && args[1] == "seal" {
+		if err := sealPuzzles(args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to seal puzzles: %v\n", err)
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// runCommand runs the maintenance command named by the leftover command
+// line arguments instead of the server, and returns the exit code.
+func runCommand(args []string) int {
+	ctx := context.Background()
+
+	switch strings.Join(args, " ") {
+	case "streaks recompute":
+		if err := handlers.RecomputeAllStreaks(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to recompute streaks: %v\n", err)
+			return 1
+		}
+		return 0
+	case "certificates generate":
+		if err := handlers.GenerateCertificates(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to generate certificates: %v\n", err)
+			return 1
+		}
+		return 0
+	case "puzzles validate":
+		// sealed levels are checked too, the command serves nothing
+		setupContent("all")
+		issues, err := handlers.ValidatePuzzles(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to validate puzzles: %v\n", err)
+			return 1
+		}
+		for _, issue := range issues {
+			fmt.Fprintln(os.Stderr, issue)
+		}
+		if len(issues) > 0 {
+			fmt.Fprintf(os.Stderr, "%d problems found\n", len(issues))
+			return 1
+		}
+		fmt.Println("all puzzles are valid")
+		return 0
+	case "badges backfill":
+		if err := handlers.BackfillBadges(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to backfill badges: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\ncommands:\n", strings.Join(args, " "))
+	fmt.Fprintln(os.Stderr, "  streaks recompute      rebuild every user's streaks from submissions")
+	fmt.Fprintln(os.Stderr, "  badges backfill        award badges already earned by existing submissions")
+	fmt.Fprintln(os.Stderr, "  certificates generate  issue certificates to everyone who meets the criteria")
+	fmt.Fprintln(os.Stderr, "  puzzles validate       check every level's markdown, hints and problem sets")
+	fmt.Fprintln(os.Stderr, "  puzzles pack <file>    write the puzzles into a tarball encrypted with PUZZLES_KEY")
+	fmt.Fprintln(os.Stderr, "  puzzles seal <dir>     write each level of PUZZLES_DIR into its own encrypted bundle")
+	return 2
+}
+
+// packPuzzles writes the puzzles currently served into an encrypted tarball
+// that PUZZLES_TARBALL can point at.
+func packPuzzles(file string) error {
+	// sealed levels would need the release schedule from the database
+	if os.Getenv("PUZZLES_BUNDLES") != "" {
+		return fmt.Errorf("pack reads plain puzzles, unset PUZZLES_BUNDLES")
+	}
+
+	key, err := puzzlesKey()
+	if err != nil {
+		return fmt.Errorf("PUZZLES_KEY must be 64 hex characters, %v", err)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := content.PackTarball(f, globals.Puzzles, key); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sealPuzzles writes every level of PUZZLES_DIR into dir as levelN.enc, for
+// PUZZLES_BUNDLES. Levels without a key in LEVEL_KEYS_DIR get a new one.
+func sealPuzzles(dir string) error {
+	src := content.Dir(puzzlesDir())
+	entries, err := src.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	var levels []int
+	for _, e := range entries {
+		level, err := strconv.Atoi(strings.TrimPrefix(e.Name(), "level"))
+		if e.IsDir() && strings.HasPrefix(e.Name(), "level") && err == nil {
+			levels = append(levels, level)
+		}
+	}
+
+	keysDir := os.Getenv("LEVEL_KEYS_DIR")
+	if keysDir == "" {
+		return fmt.Errorf("set LEVEL_KEYS_DIR to where the keys are kept")
+	}
+	keys := content.KeyDir(keysDir)
+	if err := keys.Generate(levels); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, level := range levels {
+		key, err := keys.Key(level)
+		if err != nil {
+			return err
+		}
+		if err := content.SealLevel(dir, src, level, key); err != nil {
+			return fmt.Errorf("level %d: %v", level, err)
+		}
+	}
+	fmt.Printf("sealed %d levels into %s, their keys are in %s\n", len(levels), dir, keysDir)
+	return nil
+}
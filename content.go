@@ -0,0 +1,109 @@
+package main
+
+import (
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sceptix-club/atlus/Backend/content"
+	"github.com/sceptix-club/atlus/Backend/globals"
+	"github.com/sceptix-club/atlus/Backend/handlers"
+)
+
+//go:embed schema.sql static
+var embeddedAssets embed.FS
+
+// embeddedPuzzles is set when built with -tags embed_puzzles
+var embeddedPuzzles fs.FS
+
+// setupContent picks where assets and puzzles are read from. Assets are
+// always built in. Puzzles come from the sealed PUZZLES_BUNDLES when set,
+// then PUZZLES_TARBALL, then from the binary when they were embedded, then
+// from PUZZLES_DIR. CONTENT_DIR points at a checkout whose files override the
+// rest, for development. unlock names the sealed levels that may be
+// decrypted before their release, see earlyUnlock.
+func setupContent(unlock string) {
+	globals.Assets = content.FS(embeddedAssets)
+
+	switch {
+	case os.Getenv("PUZZLES_BUNDLES") != "":
+		keys, err := levelKeys()
+		if err != nil {
+			log.Fatalf("Unable to load level keys, %v", err)
+		}
+		early := earlyUnlock(unlock)
+		globals.Puzzles = content.NewBundles(os.Getenv("PUZZLES_BUNDLES"), keys, handlers.LevelReleaseTime, early)
+	case os.Getenv("PUZZLES_TARBALL") != "":
+		key, err := puzzlesKey()
+		if err != nil {
+			log.Fatalf("PUZZLES_KEY must be 64 hex characters, %v", err)
+		}
+		globals.Puzzles, err = content.Tarball(os.Getenv("PUZZLES_TARBALL"), key)
+		if err != nil {
+			log.Fatalf("Unable to load PUZZLES_TARBALL, %v", err)
+		}
+	case embeddedPuzzles != nil:
+		sub, err := fs.Sub(embeddedPuzzles, "puzzles")
+		if err != nil {
+			log.Fatalf("Unable to load embedded puzzles, %v", err)
+		}
+		globals.Puzzles = content.FS(sub)
+	default:
+		globals.Puzzles = content.Dir(puzzlesDir())
+	}
+
+	if dir := os.Getenv("CONTENT_DIR"); dir != "" {
+		log.Printf("Overriding built in content with %s", dir)
+		globals.Assets = content.Overlay(content.Dir(dir), globals.Assets)
+		globals.Puzzles = content.Overlay(content.Dir(filepath.Join(dir, "puzzles")), globals.Puzzles)
+	}
+}
+
+func puzzlesKey() ([]byte, error) {
+	return hex.DecodeString(os.Getenv("PUZZLES_KEY"))
+}
+
+func puzzlesDir() string {
+	if dir := os.Getenv("PUZZLES_DIR"); dir != "" {
+		return dir
+	}
+	return "puzzles"
+}
+
+// levelKeys picks where the bundles' keys are read from as levels are
+// released, the files in LEVEL_KEYS_DIR or the escrow at LEVEL_KEYS_URL.
+// Neither is read at startup.
+func levelKeys() (content.LevelKeys, error) {
+	if url := os.Getenv("LEVEL_KEYS_URL"); url != "" {
+		return content.KeyURL{URL: url, Token: os.Getenv("LEVEL_KEYS_TOKEN")}, nil
+	}
+	if dir := os.Getenv("LEVEL_KEYS_DIR"); dir != "" {
+		return content.KeyDir(dir), nil
+	}
+	return nil, fmt.Errorf("set LEVEL_KEYS_DIR or LEVEL_KEYS_URL")
+}
+
+// earlyUnlock parses EARLY_UNLOCK, "all" or a list of levels like "3,4",
+// naming levels that may be decrypted before their release.
+func earlyUnlock(setting string) func(level int) bool {
+	levels := map[int]bool{}
+	all := false
+	for _, field := range strings.Split(setting, ",") {
+		field = strings.TrimSpace(field)
+		if field == "all" {
+			all = true
+		} else if level, err := strconv.Atoi(field); err == nil {
+			levels[level] = true
+		}
+	}
+	if all || len(levels) > 0 {
+		log.Printf("EARLY_UNLOCK is set to %q, those levels can be decrypted before their release", setting)
+	}
+	return func(level int) bool { return all || levels[level] }
+}
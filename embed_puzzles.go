@@ -0,0 +1,12 @@
+//go:build embed_puzzles
+
+package main
+
+import "embed"
+
+//go:embed puzzles
+var puzzles embed.FS
+
+func init() {
+	embeddedPuzzles = puzzles
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/sceptix-club/atlus/Backend/globals"
@@ -14,21 +20,95 @@ import (
 
 func main() {
 
+	// settings can come from the environment alone when there is no .env
 	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Unable to load .env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Unable to load .env, %v", err)
 	}
 	globals.Hostname = os.Getenv("HOSTNAME")
 	globals.Port = os.Getenv("PORT")
+	globals.TeamMode = os.Getenv("TEAM_MODE") == "true"
+	globals.NotifyWebhook = os.Getenv("NOTIFY_WEBHOOK_URL")
+	if size, err := strconv.Atoi(os.Getenv("TEAM_MAX_SIZE")); err == nil && size > 0 {
+		globals.TeamMaxSize = size
+	}
+	if os.Getenv("TEAM_SCORING") == globals.TeamScoringBest {
+		globals.TeamScoring = globals.TeamScoringBest
+	}
+	if levels, err := strconv.Atoi(os.Getenv("CERTIFICATE_MIN_LEVELS")); err == nil && levels > 0 {
+		globals.CertificateMinLevels = levels
+	}
+	if window := os.Getenv("STREAK_WINDOW"); window != "" {
+		globals.StreakWindow, err = time.ParseDuration(window)
+		if err != nil {
+			log.Fatalf("STREAK_WINDOW must be a duration like 24h, %v", err)
+		}
+	}
+	if window := os.Getenv("WRITEUP_WINDOW"); window != "" {
+		globals.WriteupWindow, err = time.ParseDuration(window)
+		if err != nil {
+			log.Fatalf("WRITEUP_WINDOW must be a duration like 48h, %v", err)
+		}
+	}
+	if eventEnd := os.Getenv("EVENT_END_AT"); eventEnd != "" {
+		globals.EventEnd, err = time.Parse(time.RFC3339, eventEnd)
+		if err != nil {
+			log.Fatalf("EVENT_END_AT must be an RFC3339 time, %v", err)
+		}
+	}
+	if freezeAt := os.Getenv("LEADERBOARD_FREEZE_AT"); freezeAt != "" {
+		globals.FreezeAt, err = time.Parse(time.RFC3339, freezeAt)
+		if err != nil {
+			log.Fatalf("LEADERBOARD_FREEZE_AT must be an RFC3339 time, %v", err)
+		}
+	}
+
+	dev := flag.Bool("dev", false, "DEV MODE : to truncate all db tables, on startup")
+	flag.Parse()
+
+	setupContent(os.Getenv("EARLY_UNLOCK"))
 
-	handlers.InitDB()
+	// packing and sealing only read files, so they don't need a database
+	if code, ok := runOfflineCommand(flag.Args()); ok {
+		os.Exit(code)
+	}
+
+	handlers.InitDB(*dev)
 	defer globals.DB.Close()
 
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(args))
+	}
+
+	if err := handlers.LoadPuzzles(); err != nil {
+		log.Printf("error loading puzzles, %v", err)
+	}
+	if err := handlers.LoadSchedule(); err != nil {
+		log.Printf("error loading the release schedule, %v", err)
+	}
+
+	go handlers.WatchPuzzles(context.Background())
+	go handlers.WatchLeaderboardFreeze(context.Background())
+	go handlers.WatchStreaks(context.Background())
+	go handlers.RunScheduler(context.Background())
+
 	mux := http.NewServeMux()
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	static, err := fs.Sub(globals.Assets, "static")
+	if err != nil {
+		log.Fatalf("Unable to load static files, %v", err)
+	}
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServerFS(static)))
 	tpl := template.Must(template.New("").Funcs(template.FuncMap{
 		"add": func(a int, b int) int { return a + b },
-	}).ParseGlob("static/*.html"))
+		"dict": func(kv ...any) map[string]any {
+			m := make(map[string]any, len(kv)/2)
+			for i := 0; i+1 < len(kv); i += 2 {
+				m[kv[i].(string)] = kv[i+1]
+			}
+			return m
+		},
+		"teamMode": func() bool { return globals.TeamMode },
+	}).ParseFS(globals.Assets, "static/*.html"))
 	conf := handlers.InitOAuthConfig()
 	lf := handlers.LoginFlow{Conf: conf}
 
@@ -39,9 +119,51 @@ func main() {
 	mux.HandleFunc("/puzzles/{slug}", handlers.Authenticator(handlers.LevelHandler(tpl)))
 	mux.HandleFunc("/inputs/{slug}", handlers.Authenticator(handlers.InputHandler))
 	mux.HandleFunc("/submitAnswer/{slug}", handlers.Authenticator(handlers.SubmitAnswerHandler(tpl)))
+	mux.HandleFunc("/revealHint/{slug}", handlers.Authenticator(handlers.RevealHintHandler(tpl)))
 	mux.HandleFunc("/leaderboard/", handlers.Authenticator(handlers.LeaderboardHandler(tpl)))
+	mux.HandleFunc("/leaderboard/level/{slug}", handlers.Authenticator(handlers.LevelLeaderboardHandler(tpl)))
 	mux.HandleFunc("/leaderboard/live/{slug}", handlers.LeaderboardLiveHandler(tpl))
+	mux.HandleFunc("/api/leaderboard/{slug}", handlers.LeaderboardAPIHandler)
+	mux.HandleFunc("/writeups/{slug}", handlers.Authenticator(handlers.WriteupsHandler(tpl)))
+	mux.HandleFunc("/writeups/{slug}/publish", handlers.Authenticator(handlers.PublishWriteupHandler(tpl)))
+	mux.HandleFunc("/writeups/{slug}/vote/{id}", handlers.Authenticator(handlers.VoteWriteupHandler(tpl)))
+	mux.HandleFunc("/discussion/{slug}", handlers.Authenticator(handlers.DiscussionHandler(tpl)))
+	mux.HandleFunc("/discussion/{slug}/post", handlers.Authenticator(handlers.DiscussionPostHandler(tpl)))
+	mux.HandleFunc("/discussion/{slug}/hide/{id}", handlers.AdminOnly(handlers.HidePostHandler))
+	mux.HandleFunc("/discussion/{slug}/delete/{id}", handlers.AdminOnly(handlers.DeletePostHandler))
+	mux.HandleFunc("/boards", handlers.Authenticator(handlers.BoardsHandler(tpl)))
+	mux.HandleFunc("/boards/create", handlers.Authenticator(handlers.CreateBoardHandler(tpl)))
+	mux.HandleFunc("/boards/join", handlers.Authenticator(handlers.JoinBoardHandler(tpl)))
+	mux.HandleFunc("/boards/{id}", handlers.Authenticator(handlers.BoardHandler(tpl)))
+	mux.HandleFunc("/boards/{id}/regenerate", handlers.Authenticator(handlers.RegenerateBoardCodeHandler(tpl)))
+	mux.HandleFunc("/boards/{id}/remove/{member}", handlers.Authenticator(handlers.RemoveBoardMemberHandler(tpl)))
+	mux.HandleFunc("/teams", handlers.Authenticator(handlers.TeamsHandler(tpl)))
+	mux.HandleFunc("/teams/create", handlers.Authenticator(handlers.CreateTeamHandler(tpl)))
+	mux.HandleFunc("/teams/leave", handlers.Authenticator(handlers.LeaveTeamHandler(tpl)))
+	mux.HandleFunc("/teams/join/{code}", handlers.Authenticator(handlers.JoinTeamHandler(tpl)))
+	mux.HandleFunc("/teams/{id}", handlers.Authenticator(handlers.TeamHandler(tpl)))
+	mux.HandleFunc("/admin", handlers.AdminOnly(handlers.AdminHandler(tpl)))
+	mux.HandleFunc("/admin/leaderboard/reveal", handlers.AdminOnly(handlers.RevealLeaderboardHandler))
+	mux.HandleFunc("/admin/jobs/retry", handlers.AdminOnly(handlers.RetryJobHandler))
+	mux.HandleFunc("/admin/announcements", handlers.AdminOnly(handlers.CreateAnnouncementHandler))
+	mux.HandleFunc("/admin/announcements/{id}/delete", handlers.AdminOnly(handlers.DeleteAnnouncementHandler))
 	mux.HandleFunc("/profile", handlers.Authenticator(handlers.ProfileHandler(tpl)))
+	mux.HandleFunc("/profile/visibility", handlers.Authenticator(handlers.ProfileVisibilityHandler))
+	mux.HandleFunc("/profile/calendar", handlers.Authenticator(handlers.CalendarTokenHandler))
+	mux.HandleFunc("/u/{username}", handlers.PublicProfileHandler(tpl))
+	mux.HandleFunc("/u/{username}/heatmap.svg", handlers.ActivityHeatmapHandler)
+	mux.HandleFunc("/u/{username}/timeline.svg", handlers.LevelTimelineHandler)
+	mux.HandleFunc("/badge/{file}", handlers.ShieldHandler)
+	mux.HandleFunc("/certificate", handlers.Authenticator(handlers.CertificateHandler(tpl)))
+	mux.HandleFunc("/verify/{code}", handlers.VerifyCertificateHandler(tpl))
+	mux.HandleFunc("/schedule", handlers.ScheduleHandler(tpl))
+	mux.HandleFunc("/api/schedule", handlers.ScheduleAPIHandler)
+	mux.HandleFunc("/schedule.ics", handlers.CalendarHandler)
+	mux.HandleFunc("/schedule/{file}", handlers.PersonalCalendarHandler)
+	mux.HandleFunc("/feed.atom", handlers.FeedHandler)
+	mux.HandleFunc("/announcements", handlers.AnnouncementsHandler(tpl))
+	mux.HandleFunc("/announcements/{id}/dismiss", handlers.Authenticator(handlers.DismissAnnouncementHandler))
+	mux.HandleFunc("/api/announcements", handlers.AnnouncementsAPIHandler)
 
 	fmt.Printf("Listening on %s:%s ...\n", globals.Hostname, globals.Port)
 	log.Panic(http.ListenAndServe(":"+globals.Port, mux))
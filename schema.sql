@@ -42,3 +42,212 @@ CREATE TABLE
 		passed BOOLEAN DEFAULT FALSE,
 		PRIMARY KEY (github_id, level_id)
 	);
+
+CREATE TABLE
+    IF NOT EXISTS private_boards (
+        board_id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+        name TEXT NOT NULL,
+        owner_id INT NOT NULL REFERENCES users (github_id),
+        join_code TEXT NOT NULL UNIQUE,
+        created_at TIMESTAMP DEFAULT NOW ()
+    );
+
+CREATE TABLE
+    IF NOT EXISTS private_board_members (
+        board_id INT REFERENCES private_boards (board_id) ON DELETE CASCADE,
+        github_id INT REFERENCES users (github_id),
+        joined_at TIMESTAMP DEFAULT NOW (),
+        PRIMARY KEY (board_id, github_id)
+    );
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN DEFAULT FALSE;
+
+-- a single row, present once the leaderboards have frozen
+CREATE TABLE
+    IF NOT EXISTS leaderboard_freeze (
+        id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+        frozen_at TIMESTAMP NOT NULL,
+        reveal_steps INT DEFAULT 0,
+        reveal_step INT DEFAULT 0,
+        reveal_interval INTERVAL,
+        reveal_started_at TIMESTAMP,
+        reveal_next_at TIMESTAMP,
+        revealed_at TIMESTAMP
+    );
+
+CREATE TABLE
+    IF NOT EXISTS frozen_users (
+        github_id INT PRIMARY KEY,
+        username TEXT NOT NULL,
+        github_url TEXT,
+        current_level INTEGER,
+        streak INTEGER
+    );
+
+CREATE TABLE
+    IF NOT EXISTS frozen_submissions (
+        github_id INT,
+        username TEXT NOT NULL,
+        level_id INT,
+        last_submission TIMESTAMP NOT NULL,
+        time_taken INTERVAL,
+        attempts INT,
+        passed BOOLEAN,
+        PRIMARY KEY (github_id, level_id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS teams (
+        team_id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+        name TEXT NOT NULL UNIQUE,
+        owner_id INT NOT NULL REFERENCES users (github_id),
+        invite_code TEXT NOT NULL UNIQUE,
+        created_at TIMESTAMP DEFAULT NOW ()
+    );
+
+CREATE TABLE
+    IF NOT EXISTS team_members (
+        team_id INT REFERENCES teams (team_id) ON DELETE CASCADE,
+        github_id INT UNIQUE REFERENCES users (github_id),
+        joined_at TIMESTAMP DEFAULT NOW (),
+        PRIMARY KEY (team_id, github_id)
+    );
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS longest_streak INTEGER DEFAULT 0;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS first_try_streak INTEGER DEFAULT 0;
+
+CREATE TABLE
+    IF NOT EXISTS user_badges (
+        github_id INT REFERENCES users (github_id),
+        badge TEXT NOT NULL,
+        awarded_at TIMESTAMP DEFAULT NOW (),
+        PRIMARY KEY (github_id, badge)
+    );
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS public_profile BOOLEAN DEFAULT TRUE;
+
+-- profiles are looked up by username case-insensitively. Not unique, a user
+-- who renamed on GitHub keeps their old name here until they log in again,
+-- and someone else may have taken it by then
+CREATE INDEX IF NOT EXISTS users_username_lower ON users (LOWER(username));
+
+-- the secret in a user's personal calendar feed url, NULL until they create one
+ALTER TABLE users ADD COLUMN IF NOT EXISTS calendar_token TEXT UNIQUE;
+
+CREATE TABLE
+    IF NOT EXISTS certificates (
+        code TEXT PRIMARY KEY,
+        github_id INT NOT NULL UNIQUE REFERENCES users (github_id),
+        name TEXT NOT NULL,
+        levels_solved INT NOT NULL,
+        total_levels INT NOT NULL,
+        rank INT NOT NULL,
+        issued_at TIMESTAMP DEFAULT NOW ()
+    );
+
+CREATE TABLE
+    IF NOT EXISTS hint_reveals (
+        github_id INT REFERENCES users (github_id),
+        level_id INT REFERENCES levels (level_id),
+        tier INT NOT NULL,
+        cost INT NOT NULL DEFAULT 0,
+        penalty INTERVAL NOT NULL DEFAULT INTERVAL '0',
+        revealed_at TIMESTAMP DEFAULT NOW (),
+        PRIMARY KEY (github_id, level_id, tier)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS frozen_hint_reveals (
+        github_id INT,
+        level_id INT,
+        tier INT NOT NULL,
+        cost INT NOT NULL DEFAULT 0,
+        penalty INTERVAL NOT NULL DEFAULT INTERVAL '0',
+        revealed_at TIMESTAMP,
+        PRIMARY KEY (github_id, level_id, tier)
+    );
+
+-- hint penalties used to be added into time_taken, they're only added when
+-- ranking now so time_taken is the plain time from release to solve
+UPDATE submissions s SET time_taken = s.last_submission - l.release_time
+FROM levels l
+WHERE s.level_id = l.level_id AND s.passed = TRUE
+    AND s.time_taken IS DISTINCT FROM s.last_submission - l.release_time;
+
+CREATE TABLE
+    IF NOT EXISTS writeups (
+        writeup_id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+        github_id INT NOT NULL REFERENCES users (github_id),
+        level_id INT NOT NULL REFERENCES levels (level_id),
+        body TEXT NOT NULL,
+        code_url TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP DEFAULT NOW (),
+        updated_at TIMESTAMP DEFAULT NOW (),
+        UNIQUE (github_id, level_id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS writeup_votes (
+        writeup_id INT REFERENCES writeups (writeup_id) ON DELETE CASCADE,
+        github_id INT REFERENCES users (github_id),
+        PRIMARY KEY (writeup_id, github_id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS discussion_posts (
+        post_id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+        level_id INT NOT NULL REFERENCES levels (level_id),
+        github_id INT NOT NULL REFERENCES users (github_id),
+        parent_id INT REFERENCES discussion_posts (post_id) ON DELETE CASCADE,
+        body TEXT NOT NULL,
+        hidden BOOLEAN NOT NULL DEFAULT FALSE,
+        created_at TIMESTAMP DEFAULT NOW ()
+    );
+
+CREATE TABLE
+    IF NOT EXISTS release_jobs (
+        job TEXT NOT NULL,
+        level_id INT REFERENCES levels (level_id),
+        attempts INT NOT NULL DEFAULT 0,
+        last_error TEXT NOT NULL DEFAULT '',
+        next_attempt_at TIMESTAMP DEFAULT NOW (),
+        finished_at TIMESTAMP,
+        PRIMARY KEY (job, level_id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS leaderboard_snapshots (
+        level_id INT REFERENCES levels (level_id),
+        github_id INT REFERENCES users (github_id),
+        points INT NOT NULL,
+        rank INT NOT NULL,
+        taken_at TIMESTAMP DEFAULT NOW (),
+        PRIMARY KEY (level_id, github_id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS announcements (
+        announcement_id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+        title TEXT NOT NULL,
+        body TEXT NOT NULL,
+        github_id INT REFERENCES users (github_id),
+        created_at TIMESTAMP DEFAULT NOW ()
+    );
+
+-- severity is info, warning or critical. An announcement shows from
+-- starts_at until ends_at, to everyone or only to users on level_id
+ALTER TABLE announcements ADD COLUMN IF NOT EXISTS severity TEXT NOT NULL DEFAULT 'info';
+
+ALTER TABLE announcements ADD COLUMN IF NOT EXISTS starts_at TIMESTAMP NOT NULL DEFAULT NOW ();
+
+ALTER TABLE announcements ADD COLUMN IF NOT EXISTS ends_at TIMESTAMP;
+
+ALTER TABLE announcements ADD COLUMN IF NOT EXISTS level_id INT REFERENCES levels (level_id);
+
+CREATE TABLE
+    IF NOT EXISTS announcement_dismissals (
+        announcement_id INT REFERENCES announcements (announcement_id) ON DELETE CASCADE,
+        github_id INT REFERENCES users (github_id),
+        PRIMARY KEY (announcement_id, github_id)
+    );